@@ -0,0 +1,87 @@
+// Copyright © 2017 Ricardo Aravena <raravena@branch.io>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/raravena80/scpgo/sshagent"
+	"github.com/spf13/cobra"
+)
+
+var (
+	agentSocket   string
+	agentKeyFiles []string
+	agentLifetime time.Duration
+	agentConfirm  bool
+)
+
+// agentCmd Runs an ssh-agent for hosts, e.g. CI runners, that have none
+var agentCmd = &cobra.Command{
+	Use:   "agent",
+	Short: "Run an ssh-agent on a unix socket",
+	Long: `Serves the ssh-agent protocol on the unix socket given with -a, which is
+what SSH_AUTH_SOCK should be set to. It stays in the foreground, so start it
+with & from a script. Keys given with -i are loaded at startup, asking once
+for their passphrase. The agent stops when the process that started it exits.
+`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		socket := agentSocket
+		keyring := sshagent.NewKeyring()
+		for _, keyFile := range agentKeyFiles {
+			key, err := sshagent.LoadKey(keyFile, agentLifetime, agentConfirm)
+			if err != nil {
+				return err
+			}
+			err = keyring.Add(key)
+			if err != nil {
+				return err
+			}
+		}
+		l, err := sshagent.Listen(socket)
+		if err != nil {
+			return err
+		}
+		defer os.Remove(socket)
+		defer l.Close()
+
+		done := make(chan struct{})
+		go sshagent.WatchParent(time.Second, done)
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+		served := make(chan error, 1)
+		go func() { served <- sshagent.Serve(l, keyring) }()
+		select {
+		case <-done:
+		case <-signals:
+		case err = <-served:
+			return err
+		}
+		return nil
+	},
+}
+
+func init() {
+	agentCmd.Flags().StringVarP(&agentSocket, "socket", "a", "", "Bind the agent to this unix socket, the SSH_AUTH_SOCK for its clients")
+	agentCmd.MarkFlagRequired("socket")
+	agentCmd.Flags().StringArrayVarP(&agentKeyFiles, "keyFile", "i", nil, "Load this private key into the agent, can be repeated")
+	agentCmd.Flags().DurationVarP(&agentLifetime, "lifetime", "t", 0, "Forget the loaded keys after this long, e.g. 1h (default forever)")
+	agentCmd.Flags().BoolVarP(&agentConfirm, "confirm", "c", false, "Ask for confirmation each time a loaded key is used")
+	RootCmd.AddCommand(agentCmd)
+}
@@ -0,0 +1,78 @@
+// Copyright © 2017 Ricardo Aravena <raravena@branch.io>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+// unboundFlags Flags with no scp.<name> key in the config file
+var unboundFlags = map[string]bool{
+	"config":          true,
+	"help":            true,
+	"list-algorithms": true,
+	"version":         true,
+}
+
+// flagAliases Deprecated flags and the flag each one stands for
+var flagAliases = map[string]string{
+	"key": "keyFile",
+}
+
+// applyConfig Sets the flags not given on the command line from their
+// scp.<name> keys in the config file, e.g. scp.retries or scp.knownHosts.
+// BindPFlag only lets viper read the flags, so the values go back in through
+// the flags to reach copier.
+func applyConfig(flags *pflag.FlagSet) error {
+	var err error
+	for alias, name := range flagAliases {
+		if flags.Changed(alias) {
+			flags.Lookup(name).Changed = true
+		}
+	}
+	flags.VisitAll(func(flag *pflag.Flag) {
+		if _, alias := flagAliases[flag.Name]; alias {
+			return
+		}
+		key := configKey(flag.Name)
+		// IsSet would also count the flag's default, bound with BindPFlag
+		if err != nil || flag.Changed || unboundFlags[flag.Name] || !viper.InConfig(key) {
+			return
+		}
+		if list, ok := flag.Value.(pflag.SliceValue); ok {
+			err = list.Replace(viper.GetStringSlice(key))
+		} else {
+			err = flag.Value.Set(viper.GetString(key))
+		}
+		if err != nil {
+			err = fmt.Errorf("Bad %s in %s: %v", key, viper.ConfigFileUsed(), err)
+		}
+	})
+	return err
+}
+
+// configKey Returns the key a flag is bound to: scp. and its name in
+// camelCase, so --known-hosts is scp.knownHosts
+func configKey(name string) string {
+	parts := strings.Split(name, "-")
+	for i := 1; i < len(parts); i++ {
+		parts[i] = strings.ToUpper(parts[i][:1]) + parts[i][1:]
+	}
+	return "scp." + strings.Join(parts, "")
+}
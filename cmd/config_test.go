@@ -0,0 +1,94 @@
+// Copyright © 2017 Ricardo Aravena <raravena@branch.io>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+func TestApplyConfig(t *testing.T) {
+	tests := []struct {
+		name         string
+		config       string
+		args         []string
+		keyFiles     []string
+		fingerprints []string
+		retries      int
+	}{
+		{name: "No config file"},
+		{name: "From the config file",
+			config:   "scp:\n  retries: 3\n  keyFile:\n    - /keys/id_ed25519\n",
+			keyFiles: []string{"/keys/id_ed25519"},
+			retries:  3,
+		},
+		{name: "Command line wins",
+			config:  "scp:\n  retries: 3\n",
+			args:    []string{"--retries", "5"},
+			retries: 5,
+		},
+		{name: "Deprecated alias wins too",
+			config:   "scp:\n  keyFile:\n    - /keys/id_ed25519\n",
+			args:     []string{"-k", "/keys/old", "-i", "/keys/new"},
+			keyFiles: []string{"/keys/old", "/keys/new"},
+		},
+		{name: "Other sections ignored",
+			config: "hosts:\n  h:\n    retries: 3\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			viper.Reset()
+			defer viper.Reset()
+			flags := pflag.NewFlagSet("test", pflag.ContinueOnError)
+			keyFiles := flags.StringArrayP("keyFile", "i", nil, "")
+			flags.VarP(flags.Lookup("keyFile").Value, "key", "k", "")
+			fingerprints := flags.StringArray("host-key-fingerprint", nil, "")
+			retries := flags.Int("retries", 0, "")
+			for _, name := range []string{"keyFile", "host-key-fingerprint", "retries"} {
+				viper.BindPFlag(configKey(name), flags.Lookup(name))
+			}
+			if tt.config != "" {
+				viper.SetConfigType("yaml")
+				err := viper.ReadConfig(bytes.NewBufferString(tt.config))
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			err := flags.Parse(tt.args)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = applyConfig(flags)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(*keyFiles, tt.keyFiles) {
+				t.Errorf("Value received: %q expected %q", *keyFiles, tt.keyFiles)
+			}
+			if !reflect.DeepEqual(*fingerprints, tt.fingerprints) {
+				t.Errorf("Value received: %q expected %q", *fingerprints, tt.fingerprints)
+			}
+			if *retries != tt.retries {
+				t.Errorf("Value received: %v expected %v", *retries, tt.retries)
+			}
+		})
+	}
+}
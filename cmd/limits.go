@@ -0,0 +1,78 @@
+// Copyright © 2017 Ricardo Aravena <raravena@branch.io>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/raravena80/scpgo/scp"
+	"github.com/spf13/viper"
+)
+
+// watchLimits Re-reads the bandwidth limits from the config file on SIGHUP,
+// so a running transfer can be slowed down or sped up
+func watchLimits() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	go func() {
+		for range signals {
+			err := reloadLimits(viper.ConfigFileUsed())
+			if err != nil {
+				fmt.Fprintln(os.Stderr, "Failed to reload limits: "+err.Error())
+			}
+		}
+	}()
+}
+
+// reloadLimits Applies scp.globalLimit and hosts.<name>.limit from the config
+// file. A fresh viper reads it, as flags given on the command line would
+// otherwise shadow the new values. Limits missing from the file stay as they
+// are.
+func reloadLimits(configFile string) error {
+	if configFile == "" {
+		return fmt.Errorf("no config file to read limits from")
+	}
+	v := viper.New()
+	v.SetConfigFile(configFile)
+	err := v.ReadInConfig()
+	if err != nil {
+		return err
+	}
+	if v.IsSet("scp.globalLimit") {
+		scp.GlobalLimiter.SetLimit(v.GetInt("scp.globalLimit"))
+		if !copier.IsQuiet {
+			fmt.Fprintf(os.Stderr, "Global bandwidth limit: %d Kbit/s\n", scp.GlobalLimiter.Limit())
+		}
+	}
+	// host names have dots, so no hosts.<name>.limit key lookups
+	var hosts map[string]struct{ Limit *int }
+	err = v.UnmarshalKey("hosts", &hosts)
+	if err != nil {
+		return err
+	}
+	for host, hostConfig := range hosts {
+		if hostConfig.Limit == nil {
+			continue
+		}
+		scp.HostLimiter(host).SetLimit(*hostConfig.Limit)
+		if !copier.IsQuiet {
+			fmt.Fprintf(os.Stderr, "Bandwidth limit for %s: %d Kbit/s\n", host, *hostConfig.Limit)
+		}
+	}
+	return nil
+}
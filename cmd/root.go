@@ -20,14 +20,17 @@ import (
 
 	homedir "github.com/mitchellh/go-homedir"
 	"github.com/raravena80/scpgo/scp"
+	"github.com/raravena80/scpgo/sshconn"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 )
 
 var (
-	err     error
-	cfgFile string
-	copier  scp.SecureCopier
+	err            error
+	cfgFile        string
+	configLoaded   bool
+	listAlgorithms string
+	copier         scp.SecureCopier
 	// Version For the command
 	Version string
 )
@@ -39,10 +42,42 @@ var RootCmd = &cobra.Command{
 	Long: `This is an SCP implementation in Go.
 `,
 	Run: func(cmd *cobra.Command, args []string) {
-		copier.Exec(args)
-
+		if listAlgorithms != "" {
+			algos, err := sshconn.ListAlgorithms(listAlgorithms)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			for _, algo := range algos {
+				fmt.Println(algo)
+			}
+			return
+		}
+		// per host settings, e.g. hosts.<name>.hostKeyFingerprints
+		viper.UnmarshalKey("hosts", &copier.Hosts)
+		err := applyConfig(cmd.Flags())
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		// a -l typed for this run wins over hosts.<name>.limit
+		copier.LimitGiven = cmd.Flags().Changed("limit")
+		if configLoaded || copier.Limit > 0 || copier.GlobalLimit > 0 {
+			// otherwise a hangup should still end the copy
+			watchLimits()
+		}
+		code, _ := copier.Exec(args)
+		if code != 0 {
+			os.Exit(code)
+		}
+	},
+	Args: func(cmd *cobra.Command, args []string) error {
+		// --list-algorithms is a query, nothing gets copied
+		if listAlgorithms != "" {
+			return cobra.NoArgs(cmd, args)
+		}
+		return cobra.ExactArgs(2)(cmd, args)
 	},
-	Args:    cobra.ExactArgs(2),
 	Version: Version,
 }
 
@@ -54,7 +89,7 @@ func Execute() {
 }
 
 func init() {
-	copier := scp.NewSecureCopier()
+	copier = scp.NewSecureCopier()
 	cobra.OnInitialize(initConfig)
 	RootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is $HOME/.scpgo.yaml)")
 	RootCmd.Flags().BoolVarP(&copier.IsRecursive, "recursive", "r", false, "Recursive copy")
@@ -71,10 +106,81 @@ func init() {
 	viper.BindPFlag("scp.verbose", RootCmd.Flags().Lookup("verbose"))
 	RootCmd.Flags().BoolVarP(&copier.IsCheckKnownHosts, "checkKnownHosts", "c", false, "Check known hosts")
 	viper.BindPFlag("scp.checkKnownHosts", RootCmd.Flags().Lookup("checkKnownHosts"))
-	RootCmd.Flags().StringVarP(&copier.KeyFile, "keyFile", "k", "", "Use this keyfile to authenticate")
-	viper.BindPFlag("scp.keyfile", RootCmd.Flags().Lookup("keyfile"))
+	RootCmd.Flags().StringArrayVar(&copier.KnownHostsFiles, "known-hosts", nil, "Also check host keys against this file, can be repeated (with -c)")
+	viper.BindPFlag("scp.knownHosts", RootCmd.Flags().Lookup("known-hosts"))
+	RootCmd.Flags().StringVar(&copier.StrictHostKeys, "strict-host-key-checking", "", "StrictHostKeyChecking: yes, accept-new, ask or no (default yes with -c, otherwise no)")
+	viper.BindPFlag("scp.strictHostKeyChecking", RootCmd.Flags().Lookup("strict-host-key-checking"))
+	RootCmd.Flags().StringArrayVar(&copier.HostKeyPins, "host-key-fingerprint", nil, "Only accept a host key with this SHA256 fingerprint, can be repeated (skips known_hosts)")
+	viper.BindPFlag("scp.hostKeyFingerprint", RootCmd.Flags().Lookup("host-key-fingerprint"))
+	RootCmd.Flags().StringArrayVarP(&copier.KeyFiles, "keyFile", "i", nil, "Use this keyfile (OpenSSH, PEM, PKCS#8 or PuTTY .ppk) to authenticate, can be repeated (default ~/.ssh/id_ed25519, id_ecdsa, id_rsa and the agent)")
+	viper.BindPFlag("scp.keyFile", RootCmd.Flags().Lookup("keyFile"))
+	// -k from before -i, sharing its value so both can be repeated together
+	RootCmd.Flags().VarP(RootCmd.Flags().Lookup("keyFile").Value, "key", "k", "Same as --keyFile")
+	RootCmd.Flags().MarkDeprecated("key", "use -i or --keyFile instead")
+	RootCmd.Flags().StringArrayVar(&copier.CertFiles, "certificate", nil, "Use this user certificate with the key it certifies, can be repeated (default <keyFile>-cert.pub)")
+	viper.BindPFlag("scp.certificate", RootCmd.Flags().Lookup("certificate"))
+	RootCmd.Flags().BoolVar(&copier.IdentitiesOnly, "identities-only", false, "Only offer agent keys that match the identity files, like IdentitiesOnly in ssh")
+	viper.BindPFlag("scp.identitiesOnly", RootCmd.Flags().Lookup("identities-only"))
+	RootCmd.Flags().StringArrayVar(&copier.AgentKeys, "agent-key", nil, "Only offer agent keys with this fingerprint, comment or public key file, can be repeated")
+	viper.BindPFlag("scp.agentKey", RootCmd.Flags().Lookup("agent-key"))
 	RootCmd.Flags().BoolVarP(&copier.Password, "password", "P", false, "Prompt for password input")
 	viper.BindPFlag("scp.password", RootCmd.Flags().Lookup("password"))
+	RootCmd.Flags().StringVar(&copier.PasswordFile, "password-file", "", "Read the password from the first line of this file")
+	viper.BindPFlag("scp.passwordFile", RootCmd.Flags().Lookup("password-file"))
+	RootCmd.Flags().BoolVar(&copier.Batch, "batch", false, "Fail instead of prompting for passwords, passphrases or host keys")
+	viper.BindPFlag("scp.batch", RootCmd.Flags().Lookup("batch"))
+	RootCmd.Flags().StringSliceVar(&copier.AuthMethods, "auth-methods", nil, "Authentication methods in the order to try them: publickey, keyboard-interactive, password (default publickey, and password with -P)")
+	viper.BindPFlag("scp.authMethods", RootCmd.Flags().Lookup("auth-methods"))
+	// -c already means --checkKnownHosts
+	RootCmd.Flags().StringSliceVar(&copier.Ciphers, "cipher", nil, "Ciphers in order of preference; +, - or ^ in front adds to, removes from or prepends to the defaults")
+	viper.BindPFlag("scp.cipher", RootCmd.Flags().Lookup("cipher"))
+	RootCmd.Flags().StringSliceVar(&copier.KexAlgorithms, "kex", nil, "Key exchange algorithms, same syntax as --cipher")
+	viper.BindPFlag("scp.kex", RootCmd.Flags().Lookup("kex"))
+	RootCmd.Flags().StringSliceVar(&copier.MACs, "macs", nil, "MAC algorithms, same syntax as --cipher")
+	viper.BindPFlag("scp.macs", RootCmd.Flags().Lookup("macs"))
+	RootCmd.Flags().StringSliceVar(&copier.HostKeyAlgorithms, "host-key-algorithms", nil, "Host key algorithms, same syntax as --cipher")
+	viper.BindPFlag("scp.hostKeyAlgorithms", RootCmd.Flags().Lookup("host-key-algorithms"))
+	RootCmd.Flags().StringVar(&copier.CryptoPolicy, "crypto-policy", "", "Start the algorithm lists from a preset: modern, compat or fips-like")
+	viper.BindPFlag("scp.cryptoPolicy", RootCmd.Flags().Lookup("crypto-policy"))
+	RootCmd.Flags().IntVar(&copier.ConnectTimeout, "connect-timeout", 0, "Seconds to wait for the server to answer when connecting (default no limit)")
+	viper.BindPFlag("scp.connectTimeout", RootCmd.Flags().Lookup("connect-timeout"))
+	RootCmd.Flags().IntVar(&copier.AliveInterval, "server-alive-interval", 0, "Seconds between keepalives sent to the server, like ServerAliveInterval in ssh (default none)")
+	viper.BindPFlag("scp.serverAliveInterval", RootCmd.Flags().Lookup("server-alive-interval"))
+	RootCmd.Flags().IntVar(&copier.AliveCountMax, "server-alive-count-max", sshconn.DefaultServerAliveCountMax, "Unanswered keepalives before the connection is dropped")
+	viper.BindPFlag("scp.serverAliveCountMax", RootCmd.Flags().Lookup("server-alive-count-max"))
+	RootCmd.Flags().IntVar(&copier.StallTimeout, "stall-timeout", 0, "Abort when no data has moved for this many seconds (default never)")
+	viper.BindPFlag("scp.stallTimeout", RootCmd.Flags().Lookup("stall-timeout"))
+	RootCmd.Flags().IntVar(&copier.Retries, "retries", 0, "Redial this many times after a dropped or failed connection, resending only unfinished files")
+	viper.BindPFlag("scp.retries", RootCmd.Flags().Lookup("retries"))
+	RootCmd.Flags().DurationVar(&copier.RetryBackoff, "retry-backoff", scp.DefaultRetryBackoff, "Wait before the first retry, doubled after each one up to a minute")
+	viper.BindPFlag("scp.retryBackoff", RootCmd.Flags().Lookup("retry-backoff"))
+	RootCmd.Flags().IntVarP(&copier.Limit, "limit", "l", 0, "Limit the bandwidth to each host to this many Kbit/s, like scp -l (default no limit)")
+	viper.BindPFlag("scp.limit", RootCmd.Flags().Lookup("limit"))
+	RootCmd.Flags().IntVar(&copier.GlobalLimit, "global-limit", 0, "Cap the bandwidth of all transfers together in Kbit/s; scp.globalLimit and hosts.<name>.limit are re-read from the config file on SIGHUP")
+	viper.BindPFlag("scp.globalLimit", RootCmd.Flags().Lookup("global-limit"))
+	RootCmd.Flags().IntVar(&copier.Parallel, "parallel", 1, "Send the files of a recursive upload over this many sessions of one connection")
+	viper.BindPFlag("scp.parallel", RootCmd.Flags().Lookup("parallel"))
+	RootCmd.Flags().IntVar(&copier.BufferSize, "buffer-size", scp.DefaultBufferSize, "Size in KiB of the buffers file contents are read and written in")
+	viper.BindPFlag("scp.bufferSize", RootCmd.Flags().Lookup("buffer-size"))
+	RootCmd.Flags().StringVar(&copier.Sort, "sort", scp.SortNone, "Order the entries of each directory in recursive uploads: none, name, size or mtime")
+	viper.BindPFlag("scp.sort", RootCmd.Flags().Lookup("sort"))
+	RootCmd.Flags().IntVar(&copier.MaxDepth, "max-depth", 0, "Only descend this many directory levels in recursive uploads, like find -maxdepth (default no limit)")
+	viper.BindPFlag("scp.maxDepth", RootCmd.Flags().Lookup("max-depth"))
+	RootCmd.Flags().BoolVar(&copier.ShowTotal, "total-progress", false, "Show files done, percent, rate and ETA for the whole recursive copy; uploads count the source first, downloads keep a running total")
+	viper.BindPFlag("scp.totalProgress", RootCmd.Flags().Lookup("total-progress"))
+	RootCmd.Flags().StringVar(&listAlgorithms, "list-algorithms", "", "List the supported cipher, kex, mac or key algorithms and exit")
+	RootCmd.Flags().StringSliceVar(&copier.Include, "include", nil, "Only copy files matching these patterns (recursive mode)")
+	viper.BindPFlag("scp.include", RootCmd.Flags().Lookup("include"))
+	RootCmd.Flags().StringSliceVar(&copier.Exclude, "exclude", nil, "Skip files and directories matching these patterns (recursive mode)")
+	viper.BindPFlag("scp.exclude", RootCmd.Flags().Lookup("exclude"))
+	RootCmd.Flags().StringVar(&copier.Links, "links", scp.LinksFollow, "Symlinks in recursive uploads: follow, skip or copy-as-file")
+	viper.BindPFlag("scp.links", RootCmd.Flags().Lookup("links"))
+	RootCmd.Flags().BoolVar(&copier.ReadFifos, "read-fifos", false, "Read FIFOs to EOF and send their content instead of skipping them")
+	viper.BindPFlag("scp.readFifos", RootCmd.Flags().Lookup("read-fifos"))
+	RootCmd.Flags().IntVar(&copier.RetryChanged, "retry-changed", 0, "Times to resend a file that changed size while being sent")
+	viper.BindPFlag("scp.retryChanged", RootCmd.Flags().Lookup("retry-changed"))
+	RootCmd.Flags().BoolVar(&copier.Sparse, "sparse", false, "Keep holes in sparse files: skip zero blocks on download, don't read holes on upload")
+	viper.BindPFlag("scp.sparse", RootCmd.Flags().Lookup("sparse"))
 }
 
 // initConfig reads in config file and ENV variables if set.
@@ -100,5 +206,6 @@ func initConfig() {
 	// If a config file is found, read it in.
 	if err := viper.ReadInConfig(); err == nil {
 		fmt.Println("Using config file:", viper.ConfigFileUsed())
+		configLoaded = true
 	}
 }
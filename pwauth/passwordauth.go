@@ -16,7 +16,7 @@ package pwauth
 
 import (
 	"fmt"
-	"github.com/howeyc/gopass"
+	"strings"
 )
 
 // PasswordPrompt Struct for the password prompt
@@ -27,10 +27,9 @@ type PasswordPrompt struct {
 }
 
 // ClientAuthPrompt Main creator for the Auth prompt
-func ClientAuthPrompt(userName, host string) string {
+func ClientAuthPrompt(userName, host string) (string, error) {
 	pp := NewPasswordPrompt(userName, host)
-	p, _ := pp.Password(userName)
-	return p
+	return pp.Password(userName)
 }
 
 // NewPasswordPrompt Main constructor for the password prompt
@@ -38,15 +37,48 @@ func NewPasswordPrompt(userName, host string) PasswordPrompt {
 	return PasswordPrompt{userName, host, ""}
 }
 
-// Password Main function that gets the passwrod from the system
-func (p PasswordPrompt) Password(userName string) (string, error) {
+// Password Main function that gets the password, asking only once per user@host
+func (p *PasswordPrompt) Password(userName string) (string, error) {
 	if userName != "" {
 		p.UserName = userName
 	}
-	if p.password == "" {
-		fmt.Printf("%s@%s's password:", p.UserName, p.Host)
-		pass, _ := gopass.GetPasswd()
-		p.password = string(pass)
+	if p.password != "" {
+		return p.password, nil
 	}
-	return p.password, nil
+	key := p.UserName + "@" + p.Host
+	promptMu.Lock()
+	cached, ok := passwords[key]
+	promptMu.Unlock()
+	if ok {
+		p.password = cached
+		return cached, nil
+	}
+	pass, err := promptPassword(currentPrompter(), fmt.Sprintf("%s's password: ", key))
+	if err != nil {
+		return "", err
+	}
+	promptMu.Lock()
+	passwords[key] = pass
+	promptMu.Unlock()
+	p.password = pass
+	return pass, nil
+}
+
+// KeyPassphrasePrompt Asks for the passphrase of an encrypted private key
+func KeyPassphrasePrompt(keyFile string) (string, error) {
+	return currentPrompter().Prompt(fmt.Sprintf("Enter passphrase for key '%s': ", keyFile), false)
+}
+
+// ConfirmPrompt Asks a yes/no question, anything but yes is a no
+func ConfirmPrompt(question string) (bool, error) {
+	answer, err := AnswerPrompt(question, true)
+	if err != nil {
+		return false, err
+	}
+	return strings.ToLower(strings.TrimSpace(answer)) == "yes", nil
+}
+
+// AnswerPrompt Shows a prompt and reads the answer, without echo unless asked
+func AnswerPrompt(prompt string, echo bool) (string, error) {
+	return currentPrompter().Prompt(prompt, echo)
 }
@@ -73,7 +73,7 @@ func TestClientAuthPrompt(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			returned := ClientAuthPrompt(tt.username, tt.host)
+			returned, _ := ClientAuthPrompt(tt.username, tt.host)
 			if !reflect.DeepEqual(returned, tt.expected) {
 				t.Errorf("Value received: %v expected %v", returned, tt.expected)
 			}
@@ -0,0 +1,217 @@
+// Copyright © 2017 Ricardo Aravena <raravena@branch.io>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pwauth
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+
+	"github.com/howeyc/gopass"
+)
+
+// PasswordEnv Environment variable holding a password, like sshpass -e
+const PasswordEnv = "SCPGO_PASSWORD"
+
+// ErrNoAnswer Returned by a Prompter that can't answer, so the next one is asked
+var ErrNoAnswer = errors.New("No answer available for prompt")
+
+// Prompter Asks the user for passwords, passphrases and other answers
+type Prompter interface {
+	// Prompt Shows prompt and returns the answer, echoed only if echo is set
+	Prompt(prompt string, echo bool) (string, error)
+}
+
+// PasswordPrompter A Prompter that knows the login password, and so only
+// answers the password prompt, not passphrases or server questions
+type PasswordPrompter interface {
+	// PromptPassword Shows the password prompt and returns the password
+	PromptPassword(prompt string) (string, error)
+}
+
+// TTYPrompter Prompts on the controlling terminal, away from the progress
+// output, falling back to stdin and stderr when there is no terminal
+type TTYPrompter struct {
+	Path string
+}
+
+func (p TTYPrompter) Prompt(prompt string, echo bool) (string, error) {
+	var r gopass.FdReader = os.Stdin
+	var w io.Writer = os.Stderr
+	tty, err := os.OpenFile(p.Path, os.O_RDWR, 0)
+	if err == nil {
+		defer tty.Close()
+		r, w = tty, tty
+	}
+	if !echo {
+		answer, err := gopass.GetPasswdPrompt(prompt, false, r, w)
+		return string(answer), err
+	}
+	fmt.Fprint(w, prompt)
+	answer, err := bufio.NewReader(r).ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(answer, "\r\n"), nil
+}
+
+// AskpassPrompter Runs an SSH_ASKPASS program with the prompt as argument
+type AskpassPrompter struct {
+	Program string
+}
+
+func (p AskpassPrompter) Prompt(prompt string, echo bool) (string, error) {
+	cmd := exec.Command(p.Program, prompt)
+	cmd.Stderr = os.Stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("%s failed: %v", p.Program, err)
+	}
+	return strings.TrimRight(string(out), "\r\n"), nil
+}
+
+// FilePrompter Answers the password prompt with the first line of a file
+type FilePrompter struct {
+	Path string
+}
+
+func (p FilePrompter) Prompt(prompt string, echo bool) (string, error) {
+	return "", ErrNoAnswer
+}
+
+func (p FilePrompter) PromptPassword(prompt string) (string, error) {
+	b, err := ioutil.ReadFile(p.Path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(strings.SplitN(string(b), "\n", 2)[0], "\r"), nil
+}
+
+// EnvPrompter Answers the password prompt with the value of an environment
+// variable
+type EnvPrompter struct {
+	Name string
+}
+
+func (p EnvPrompter) Prompt(prompt string, echo bool) (string, error) {
+	return "", ErrNoAnswer
+}
+
+func (p EnvPrompter) PromptPassword(prompt string) (string, error) {
+	value, ok := os.LookupEnv(p.Name)
+	if !ok {
+		return "", ErrNoAnswer
+	}
+	return value, nil
+}
+
+// BatchPrompter Fails instead of prompting
+type BatchPrompter struct{}
+
+func (BatchPrompter) Prompt(prompt string, echo bool) (string, error) {
+	return "", fmt.Errorf("Not prompting for %q in batch mode", strings.TrimSpace(prompt))
+}
+
+// Chain Asks each Prompter in turn until one can answer
+type Chain []Prompter
+
+func (c Chain) Prompt(prompt string, echo bool) (string, error) {
+	for _, p := range c {
+		answer, err := p.Prompt(prompt, echo)
+		if err != ErrNoAnswer {
+			return answer, err
+		}
+	}
+	return "", ErrNoAnswer
+}
+
+func (c Chain) PromptPassword(prompt string) (string, error) {
+	for _, p := range c {
+		answer, err := promptPassword(p, prompt)
+		if err != ErrNoAnswer {
+			return answer, err
+		}
+	}
+	return "", ErrNoAnswer
+}
+
+// promptPassword Asks p for the password, as a hidden prompt if p doesn't
+// tell the password apart
+func promptPassword(p Prompter, prompt string) (string, error) {
+	if pp, ok := p.(PasswordPrompter); ok {
+		return pp.PromptPassword(prompt)
+	}
+	return p.Prompt(prompt, false)
+}
+
+// NewPrompter Picks the prompters for the run: a password file and the
+// SCPGO_PASSWORD variable first, then either nothing in batch mode,
+// SSH_ASKPASS as ssh would use it, or the terminal
+func NewPrompter(batch bool, passwordFile string) Prompter {
+	var chain Chain
+	if passwordFile != "" {
+		chain = append(chain, FilePrompter{passwordFile})
+	}
+	chain = append(chain, EnvPrompter{PasswordEnv})
+	askpass := os.Getenv("SSH_ASKPASS")
+	require := os.Getenv("SSH_ASKPASS_REQUIRE")
+	switch {
+	case batch:
+		chain = append(chain, BatchPrompter{})
+	case askpass != "" && require != "never" && (require == "force" || require == "prefer" || !haveTTY()):
+		chain = append(chain, AskpassPrompter{askpass})
+	default:
+		chain = append(chain, TTYPrompter{DefaultTTY})
+	}
+	return chain
+}
+
+// DefaultTTY The controlling terminal
+const DefaultTTY = "/dev/tty"
+
+func haveTTY() bool {
+	tty, err := os.OpenFile(DefaultTTY, os.O_RDWR, 0)
+	if err != nil {
+		return false
+	}
+	tty.Close()
+	return true
+}
+
+var (
+	promptMu sync.Mutex
+	prompter Prompter = TTYPrompter{DefaultTTY}
+	// passwords Cached per user@host for the life of the process
+	passwords = map[string]string{}
+)
+
+// SetPrompter Sets the Prompter used for everything the package asks
+func SetPrompter(p Prompter) {
+	promptMu.Lock()
+	defer promptMu.Unlock()
+	prompter = p
+}
+
+func currentPrompter() Prompter {
+	promptMu.Lock()
+	defer promptMu.Unlock()
+	return prompter
+}
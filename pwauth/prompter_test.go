@@ -0,0 +1,122 @@
+// Copyright © 2017 Ricardo Aravena <raravena@branch.io>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pwauth
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+// countingPrompter Answers every prompt with the same value and counts them
+type countingPrompter struct {
+	answer string
+	count  int
+}
+
+func (p *countingPrompter) Prompt(prompt string, echo bool) (string, error) {
+	p.count++
+	return p.answer, nil
+}
+
+func TestChain(t *testing.T) {
+	dir, err := ioutil.TempDir("", "scpgo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	passwordFile := filepath.Join(dir, "password")
+	ioutil.WriteFile(passwordFile, []byte("fromfile\nignored\n"), 0600)
+	os.Setenv("SCPGO_TEST_PASSWORD", "fromenv")
+	defer os.Unsetenv("SCPGO_TEST_PASSWORD")
+
+	tests := []struct {
+		name     string
+		chain    Chain
+		password bool
+		echo     bool
+		expected string
+		fails    bool
+	}{
+		{name: "File", chain: Chain{FilePrompter{passwordFile}, BatchPrompter{}}, password: true, expected: "fromfile"},
+		{name: "Env", chain: Chain{EnvPrompter{"SCPGO_TEST_PASSWORD"}, BatchPrompter{}}, password: true, expected: "fromenv"},
+		{name: "Unset env falls through", chain: Chain{EnvPrompter{"SCPGO_TEST_UNSET"}, &countingPrompter{answer: "next"}}, password: true, expected: "next"},
+		{name: "Passphrase falls through", chain: Chain{FilePrompter{passwordFile}, EnvPrompter{"SCPGO_TEST_PASSWORD"}, &countingPrompter{answer: "typed"}}, expected: "typed"},
+		{name: "Echoed prompt falls through", chain: Chain{FilePrompter{passwordFile}, &countingPrompter{answer: "typed"}}, echo: true, expected: "typed"},
+		{name: "Batch fails", chain: Chain{FilePrompter{passwordFile}, BatchPrompter{}}, echo: true, fails: true},
+		{name: "Missing file", chain: Chain{FilePrompter{filepath.Join(dir, "missing")}}, password: true, fails: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var returned string
+			var err error
+			if tt.password {
+				returned, err = tt.chain.PromptPassword("Password: ")
+			} else {
+				returned, err = tt.chain.Prompt("Password: ", tt.echo)
+			}
+			if (err != nil) != tt.fails {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if returned != tt.expected {
+				t.Errorf("Value received: %v expected %v", returned, tt.expected)
+			}
+		})
+	}
+}
+
+func TestAskpassPrompter(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Needs a shell script")
+	}
+	dir, err := ioutil.TempDir("", "scpgo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	program := filepath.Join(dir, "askpass")
+	ioutil.WriteFile(program, []byte("#!/bin/sh\necho \"answer to $1\"\n"), 0700)
+	returned, err := AskpassPrompter{program}.Prompt("Password:", false)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if returned != "answer to Password:" {
+		t.Errorf("Value received: %v expected %v", returned, "answer to Password:")
+	}
+}
+
+func TestPasswordCache(t *testing.T) {
+	counter := &countingPrompter{answer: "cached"}
+	SetPrompter(counter)
+	defer SetPrompter(TTYPrompter{DefaultTTY})
+	for i := 0; i < 3; i++ {
+		pp := NewPasswordPrompt("cacheuser", "cachehost")
+		returned, err := pp.Password("")
+		if err != nil || returned != "cached" {
+			t.Errorf("Value received: %v (%v) expected %v", returned, err, "cached")
+		}
+	}
+	if counter.count != 1 {
+		t.Errorf("Value received: %v expected %v", counter.count, 1)
+	}
+	pp := NewPasswordPrompt("cacheuser", "otherhost")
+	pp.Password("")
+	if counter.count != 2 {
+		t.Errorf("Value received: %v expected %v", counter.count, 2)
+	}
+}
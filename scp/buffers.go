@@ -0,0 +1,142 @@
+// Copyright © 2017 Ricardo Aravena <raravena@branch.io>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package scp
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// DefaultBufferSize Size in KiB of the buffers file bodies go through
+const DefaultBufferSize = 256
+
+// readAheadDepth Buffers an upload reads ahead of the one being sent
+const readAheadDepth = 4
+
+// progressInterval Least time between two updates of a progress bar
+const progressInterval = 100 * time.Millisecond
+
+// bufferPool Buffers shared by all transfers, kept as pointers so putting
+// them back doesn't allocate
+var bufferPool sync.Pool
+
+// getBuffer Returns a buffer of size bytes from the pool
+func getBuffer(size int) *[]byte {
+	if buf, ok := bufferPool.Get().(*[]byte); ok && cap(*buf) >= size {
+		*buf = (*buf)[:size]
+		return buf
+	}
+	buf := make([]byte, size)
+	return &buf
+}
+
+// putBuffer Hands a buffer back to the pool
+func putBuffer(buf *[]byte) {
+	bufferPool.Put(buf)
+}
+
+// bufferSize Returns the buffer size in bytes, from --buffer-size
+func (scp *SecureCopier) bufferSize() int {
+	if scp.BufferSize <= 0 {
+		return DefaultBufferSize * 1024
+	}
+	return scp.BufferSize * 1024
+}
+
+// readAhead Reads the next buffers of a file while the last one is being
+// sent, so the disk and the connection are busy at the same time
+type readAhead struct {
+	full chan *[]byte
+	done chan struct{}
+	err  error
+}
+
+// newReadAhead Starts reading up to size bytes of r in buffers of bufSize
+func newReadAhead(r io.Reader, size int64, bufSize int) *readAhead {
+	ra := &readAhead{
+		full: make(chan *[]byte, readAheadDepth),
+		done: make(chan struct{}),
+	}
+	go ra.fill(r, size, bufSize)
+	return ra
+}
+
+func (ra *readAhead) fill(r io.Reader, left int64, bufSize int) {
+	defer close(ra.full)
+	for left > 0 {
+		buf := getBuffer(bufSize)
+		if int64(len(*buf)) > left {
+			*buf = (*buf)[:left]
+		}
+		n, err := io.ReadFull(r, *buf)
+		*buf = (*buf)[:n]
+		left -= int64(n)
+		if n == 0 {
+			putBuffer(buf)
+		} else {
+			select {
+			case ra.full <- buf:
+			case <-ra.done:
+				putBuffer(buf)
+				return
+			}
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			// the file shrank, the caller pads it
+			return
+		}
+		if err != nil {
+			ra.err = err
+			return
+		}
+	}
+}
+
+// WriteTo Writes the buffers to w in order as they are filled
+func (ra *readAhead) WriteTo(w io.Writer) (int64, error) {
+	written := int64(0)
+	for buf := range ra.full {
+		n, err := w.Write(*buf)
+		putBuffer(buf)
+		written += int64(n)
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, ra.err
+}
+
+// Close Stops reading and waits for the reader to let go of the file
+func (ra *readAhead) Close() {
+	close(ra.done)
+	for buf := range ra.full {
+		putBuffer(buf)
+	}
+}
+
+// copyBody Sends size bytes of a file body, reading ahead when it takes
+// more than one buffer
+func (scp *SecureCopier) copyBody(w io.Writer, r io.Reader, size int64) (int64, error) {
+	bufSize := scp.bufferSize()
+	if size <= int64(bufSize) {
+		buf := getBuffer(bufSize)
+		defer putBuffer(buf)
+		return io.CopyBuffer(w, io.LimitReader(r, size), *buf)
+	}
+	ra := newReadAhead(r, size, bufSize)
+	defer ra.Close()
+	return ra.WriteTo(w)
+}
@@ -0,0 +1,363 @@
+// Copyright © 2017 Ricardo Aravena <raravena@branch.io>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package scp
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// failingWriter Accepts limit bytes, then fails
+type failingWriter struct {
+	limit int
+}
+
+func (fw *failingWriter) Write(p []byte) (int, error) {
+	if len(p) > fw.limit {
+		n := fw.limit
+		fw.limit = 0
+		return n, errors.New("connection lost")
+	}
+	fw.limit -= len(p)
+	return len(p), nil
+}
+
+func TestCopyBody(t *testing.T) {
+	data := make([]byte, 10<<10)
+	rand.New(rand.NewSource(1)).Read(data)
+	tests := []struct {
+		name     string
+		data     []byte
+		size     int64
+		expected int64
+	}{
+		{name: "Within one buffer",
+			data:     data[:512],
+			size:     512,
+			expected: 512,
+		},
+		{name: "Read ahead over several buffers",
+			data:     data,
+			size:     int64(len(data)),
+			expected: int64(len(data)),
+		},
+		{name: "Only the announced size",
+			data:     data,
+			size:     3000,
+			expected: 3000,
+		},
+		{name: "File shrank",
+			data:     data[:2500],
+			size:     int64(len(data)),
+			expected: 2500,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			copier := NewSecureCopier()
+			copier.BufferSize = 1
+			var w bytes.Buffer
+			n, err := copier.copyBody(&w, bytes.NewReader(tt.data), tt.size)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if n != tt.expected {
+				t.Errorf("Value received: %v expected %v", n, tt.expected)
+			}
+			if !bytes.Equal(w.Bytes(), tt.data[:tt.expected]) {
+				t.Errorf("Sent body does not match the file")
+			}
+		})
+	}
+}
+
+func TestCopyBodyWriteError(t *testing.T) {
+	copier := NewSecureCopier()
+	copier.BufferSize = 1
+	data := make([]byte, 64<<10)
+	n, err := copier.copyBody(&failingWriter{limit: 5000}, bytes.NewReader(data), int64(len(data)))
+	if err == nil {
+		t.Errorf("Expected the write error")
+	}
+	if n != 5000 {
+		t.Errorf("Value received: %v expected %v", n, 5000)
+	}
+}
+
+func TestGetBuffer(t *testing.T) {
+	buf := getBuffer(1024)
+	if len(*buf) != 1024 {
+		t.Errorf("Value received: %v expected %v", len(*buf), 1024)
+	}
+	putBuffer(buf)
+	// a smaller request can reuse it, a bigger one can't
+	small := getBuffer(512)
+	if len(*small) != 512 {
+		t.Errorf("Value received: %v expected %v", len(*small), 512)
+	}
+	putBuffer(small)
+	big := getBuffer(4096)
+	if len(*big) != 4096 {
+		t.Errorf("Value received: %v expected %v", len(*big), 4096)
+	}
+}
+
+func TestWriteSparseBlocks(t *testing.T) {
+	dir, err := ioutil.TempDir("", "scpgo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	f, err := os.Create(filepath.Join(dir, "out"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	b := make([]byte, 4*sparseBlock)
+	b[sparseBlock+1] = 1
+	holes, err := writeSparseBlocks(f, b)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if holes != 3*sparseBlock {
+		t.Errorf("Value received: %v expected %v", holes, 3*sparseBlock)
+	}
+}
+
+// sourceFile Plays the remote 'scp -f' side for one file
+func sourceFile(w io.WriteCloser, acks io.Reader, body []byte) {
+	defer w.Close()
+	ack := make([]byte, 1)
+	acks.Read(ack)
+	fmt.Fprintf(w, "C0644 %d bench\n", len(body))
+	acks.Read(ack)
+	w.Write(body)
+	w.Write([]byte{0})
+	acks.Read(ack)
+}
+
+// sinkFile Plays the remote 'scp -t' side for one file, acking its header
+// and body and dropping the data
+func sinkFile(ch ssh.Channel) {
+	r := bufio.NewReader(ch)
+	ch.Write([]byte{0})
+	header, err := r.ReadString('\n')
+	if err != nil {
+		return
+	}
+	var size int64
+	fmt.Sscanf(header, "C%o %d", new(uint32), &size)
+	ch.Write([]byte{0})
+	// the body and the null byte after it
+	io.CopyN(ioutil.Discard, r, size+1)
+	ch.Write([]byte{0})
+}
+
+// channelWriter Closes only its side of the channel, so the exit status can
+// still follow
+type channelWriter struct {
+	ssh.Channel
+}
+
+func (c channelWriter) Close() error {
+	return c.CloseWrite()
+}
+
+// benchClient Connects to an ssh server on a local port that runs handle for
+// each exec request, so the data goes through real channels with their
+// windows and packets
+func benchClient(b *testing.B, handle func(ch ssh.Channel)) *ssh.Client {
+	_, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		b.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		b.Fatal(err)
+	}
+	config := &ssh.ServerConfig{NoClientAuth: true}
+	config.AddHostKey(signer)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		b.Fatal(err)
+	}
+	go func() {
+		defer l.Close()
+		c, err := l.Accept()
+		if err != nil {
+			return
+		}
+		_, chans, reqs, err := ssh.NewServerConn(c, config)
+		if err != nil {
+			return
+		}
+		go ssh.DiscardRequests(reqs)
+		for newChannel := range chans {
+			ch, requests, err := newChannel.Accept()
+			if err != nil {
+				continue
+			}
+			go func() {
+				for req := range requests {
+					req.Reply(req.Type == "exec", nil)
+					if req.Type != "exec" {
+						continue
+					}
+					handle(ch)
+					ch.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{0}))
+					ch.Close()
+				}
+			}()
+		}
+	}()
+	client, err := ssh.Dial("tcp", l.Addr().String(), &ssh.ClientConfig{
+		User:            "bench",
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	})
+	if err != nil {
+		b.Fatal(err)
+	}
+	return client
+}
+
+func BenchmarkReceive(b *testing.B) {
+	body := make([]byte, 16<<20)
+	rand.New(rand.NewSource(1)).Read(body)
+	client := benchClient(b, func(ch ssh.Channel) {
+		sourceFile(channelWriter{ch}, ch, body)
+	})
+	defer client.Close()
+	for _, bufferSize := range []int{4, DefaultBufferSize} {
+		b.Run(fmt.Sprintf("%dKiB", bufferSize), func(b *testing.B) {
+			dir, err := ioutil.TempDir("", "scpgo")
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+			copier := NewSecureCopier()
+			copier.outPipe = ioutil.Discard
+			copier.errPipe = ioutil.Discard
+			copier.BufferSize = bufferSize
+			copier.dstFile = filepath.Join(dir, "out")
+			b.SetBytes(int64(len(body)))
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				session, err := client.NewSession()
+				if err != nil {
+					b.Fatal(err)
+				}
+				cw, err := session.StdinPipe()
+				if err != nil {
+					b.Fatal(err)
+				}
+				r, err := session.StdoutPipe()
+				if err != nil {
+					b.Fatal(err)
+				}
+				err = session.Start("scp -f bench")
+				if err != nil {
+					b.Fatal(err)
+				}
+				ce := make(chan error, 1)
+				copier.receive(cw, r, dir, true, ce)
+				select {
+				case err := <-ce:
+					b.Fatalf("Unexpected error: %v", err)
+				default:
+				}
+				err = session.Wait()
+				if err != nil {
+					b.Fatalf("Unexpected error: %v", err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkSendFile(b *testing.B) {
+	dir, err := ioutil.TempDir("", "scpgo")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	src := filepath.Join(dir, "in")
+	body := make([]byte, 16<<20)
+	rand.New(rand.NewSource(1)).Read(body)
+	err = ioutil.WriteFile(src, body, 0644)
+	if err != nil {
+		b.Fatal(err)
+	}
+	fi, err := os.Stat(src)
+	if err != nil {
+		b.Fatal(err)
+	}
+	client := benchClient(b, sinkFile)
+	defer client.Close()
+	for _, bufferSize := range []int{4, DefaultBufferSize} {
+		b.Run(fmt.Sprintf("%dKiB", bufferSize), func(b *testing.B) {
+			copier := NewSecureCopier()
+			copier.outPipe = ioutil.Discard
+			copier.errPipe = ioutil.Discard
+			copier.BufferSize = bufferSize
+			b.SetBytes(fi.Size())
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				session, err := client.NewSession()
+				if err != nil {
+					b.Fatal(err)
+				}
+				w, err := session.StdinPipe()
+				if err != nil {
+					b.Fatal(err)
+				}
+				acks, err := session.StdoutPipe()
+				if err != nil {
+					b.Fatal(err)
+				}
+				err = session.Start("scp -t bench")
+				if err != nil {
+					b.Fatal(err)
+				}
+				copier.acks = acks
+				err = copier.response()
+				if err == nil {
+					_, err = copier.sendFileOnce(w, src, fi)
+				}
+				w.Close()
+				if err == nil {
+					err = session.Wait()
+				}
+				if err != nil {
+					b.Fatalf("Unexpected error: %v", err)
+				}
+			}
+		})
+	}
+}
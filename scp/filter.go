@@ -0,0 +1,48 @@
+// Copyright © 2017 Ricardo Aravena <raravena@branch.io>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package scp
+
+import (
+	"path/filepath"
+)
+
+// matchesAny Checks a relative path, and its base name, against patterns
+func matchesAny(patterns []string, relPath string) bool {
+	relPath = filepath.ToSlash(relPath)
+	base := filepath.Base(relPath)
+	for _, pattern := range patterns {
+		pattern = filepath.ToSlash(pattern)
+		if ok, _ := filepath.Match(pattern, relPath); ok {
+			return true
+		}
+		if ok, _ := filepath.Match(pattern, base); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// isFiltered Tells whether an entry of a recursive copy should be left out.
+// Excludes apply to files and directories; includes only restrict files so
+// that matching files further down the tree can still be reached.
+func (scp *SecureCopier) isFiltered(relPath string, isDir bool) bool {
+	if matchesAny(scp.Exclude, relPath) {
+		return true
+	}
+	if isDir || len(scp.Include) == 0 {
+		return false
+	}
+	return !matchesAny(scp.Include, relPath)
+}
@@ -19,13 +19,13 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
 
 	"github.com/raravena80/scpgo/sshconn"
-	"golang.org/x/crypto/ssh"
 )
 
 // scp FROM remote source
@@ -50,17 +50,72 @@ func (scp *SecureCopier) scpFromRemote() error {
 		dstDir = filepath.Dir(scp.dstFile)
 		useSpecifiedFilename = true
 	}
-	// from scp
-	session, err := sshconn.Connect(scp.srcUser, scp.srcHost, scp.Port, scp.KeyFile, scp.Password, scp.IsCheckKnownHosts, scp.IsVerbose, scp.errPipe)
+	if scp.Parallel > 1 {
+		scp.warn("--parallel only applies to uploads")
+	}
+	if scp.ShowTotal && scp.IsRecursive {
+		// no sizes before they arrive, only a running total
+		scp.job = NewJobProgress(0, 0)
+	}
+	scp.limits = scp.limiters(scp.srcHost)
+	err = scp.withRetries(func() error {
+		return scp.downloadOnce(dstDir, useSpecifiedFilename)
+	})
+	scp.reportSparse()
+	scp.reportRetries()
+	return err
+}
+
+// downloadOnce Connects and receives the source. The remote sends every
+// file again, so those completed by an earlier attempt are read past.
+func (scp *SecureCopier) downloadOnce(dstDir string, useSpecifiedFilename bool) error {
+	monitor := sshconn.NewMonitor()
+	cfg := scp.connConfig(scp.srcUser, scp.srcHost)
+	cfg.Monitor = monitor
+	client, err := sshconn.Dial(cfg)
 	if err != nil {
 		return err
+	}
+	defer client.Close()
+	defer monitor.Stop()
+	session, err := client.NewSession()
+	if err != nil {
+		if scp.IsVerbose {
+			fmt.Fprintln(scp.errPipe, "Failed to create session: "+err.Error())
+		}
+		return err
 	} else if scp.IsVerbose {
 		fmt.Fprintln(scp.errPipe, "Got session")
 	}
 	defer session.Close()
+	cw, err := session.StdinPipe()
+	if err != nil {
+		fmt.Fprintln(scp.errPipe, err.Error())
+		return err
+	}
+	r, err := session.StdoutPipe()
+	if err != nil {
+		fmt.Fprintln(scp.errPipe, err.Error())
+		return err
+	}
 	ce := make(chan error)
+	firstErr := make(chan error, 1)
+	go func() {
+		// keep the first error and stop the remote on it
+		for err := range ce {
+			select {
+			case firstErr <- err:
+				session.Close()
+			default:
+			}
+		}
+	}()
 	// start the copy operation
-	go scp.doFromRemote(session, dstDir, useSpecifiedFilename, ce)
+	go func() {
+		defer close(ce)
+		defer cw.Close()
+		scp.receive(monitor.Writer(cw), monitor.Reader(r), dstDir, useSpecifiedFilename, ce)
+	}()
 	remoteOpts := "-f"
 	if scp.IsQuiet {
 		remoteOpts += "q"
@@ -68,31 +123,40 @@ func (scp *SecureCopier) scpFromRemote() error {
 	if scp.IsRecursive {
 		remoteOpts += "r"
 	}
-	err = session.Run("/usr/bin/scp " + remoteOpts + " " + scp.srcFile)
+	err = session.Run("/usr/bin/scp " + remoteOpts + " " + shellQuote(scp.srcFile))
+	select {
+	case recvErr := <-firstErr:
+		err = recvErr
+	default:
+	}
+	if mErr := monitor.Err(); mErr != nil {
+		err = mErr
+	}
 	if err != nil {
 		fmt.Fprintln(scp.errPipe, "Failed to run remote scp: "+err.Error())
 	}
 	return err
 }
 
-func (scp *SecureCopier) doFromRemote(session *ssh.Session, dstDir string, useSpecifiedFilename bool, ce chan<- error) {
-	cw, err := session.StdinPipe()
-	if err != nil {
-		fmt.Fprintln(scp.errPipe, err.Error())
-		ce <- err
-		return
-	}
-	defer cw.Close()
-	r, err := session.StdoutPipe()
-	if err != nil {
-		fmt.Fprintln(scp.errPipe, "session stdout err: "+err.Error()+" continue anyway")
-		ce <- err
-		return
-	}
+// sinkState Tracks where the receive loop is in the remote tree
+type sinkState struct {
+	dstDir    string
+	dirs      []string
+	skipDepth int
+	first     bool
+}
+
+// relPath Returns the path of name relative to the top-level item
+func (st *sinkState) relPath(name string) string {
+	return filepath.Join(append(append([]string{}, st.dirs...), name)...)
+}
+
+// receive Runs the sink side of the protocol over the remote's pipes
+func (scp *SecureCopier) receive(cw io.WriteCloser, r io.Reader, dstDir string, useSpecifiedFilename bool, ce chan<- error) {
 	if scp.IsVerbose {
 		fmt.Fprintln(scp.errPipe, "Sending null byte")
 	}
-	err = sendByte(cw, 0)
+	err := sendByte(cw, 0)
 	if err != nil {
 		fmt.Fprintln(scp.errPipe, "Write error: "+err.Error())
 		ce <- err
@@ -100,8 +164,8 @@ func (scp *SecureCopier) doFromRemote(session *ssh.Session, dstDir string, useSp
 	}
 	// use a scanner for processing individual commands, but not files themselves
 	scanner := bufio.NewScanner(r)
+	st := &sinkState{dstDir: dstDir, first: true}
 	more := true
-	first := true
 	for more {
 		cmdArr := make([]byte, 1)
 		n, err := r.Read(cmdArr)
@@ -134,7 +198,16 @@ func (scp *SecureCopier) doFromRemote(session *ssh.Session, dstDir string, useSp
 			}
 		case 'E':
 			// E command: go back out of dir
-			dstDir = filepath.Dir(dstDir)
+			// consume the rest of the line so its newline isn't read as a command
+			scanner.Scan()
+			if st.skipDepth > 0 {
+				st.skipDepth--
+			} else {
+				st.dstDir = filepath.Dir(st.dstDir)
+				if len(st.dirs) > 0 {
+					st.dirs = st.dirs[:len(st.dirs)-1]
+				}
+			}
 			if scp.IsVerbose {
 				fmt.Fprintf(scp.errPipe, "Received End-Dir\n")
 			}
@@ -159,9 +232,9 @@ func (scp *SecureCopier) doFromRemote(session *ssh.Session, dstDir string, useSp
 
 			return
 		default:
-			scp.handleDefault(scanner, cmd, first, cw, r, n, dstDir, useSpecifiedFilename, ce)
+			scp.handleDefault(scanner, cmd, st, cw, r, useSpecifiedFilename, ce)
 		}
-		first = false
+		st.first = false
 	}
 	err = cw.Close()
 	if err != nil {
@@ -172,7 +245,7 @@ func (scp *SecureCopier) doFromRemote(session *ssh.Session, dstDir string, useSp
 }
 
 // This is kind of ugly but reduces complexity for now
-func (scp *SecureCopier) handleDefault(scanner *bufio.Scanner, cmd byte, first bool, cw io.WriteCloser, r io.Reader, n int, dstDir string, useSpecifiedFilename bool, ce chan<- error) {
+func (scp *SecureCopier) handleDefault(scanner *bufio.Scanner, cmd byte, st *sinkState, cw io.WriteCloser, r io.Reader, useSpecifiedFilename bool, ce chan<- error) {
 	scanner.Scan()
 	err := scanner.Err()
 	if err != nil {
@@ -220,7 +293,7 @@ func (scp *SecureCopier) handleDefault(scanner *bufio.Scanner, cmd byte, first b
 		}
 		var filename string
 		// use the specified filename from the destination (only for top-level item)
-		if useSpecifiedFilename && first {
+		if useSpecifiedFilename && st.first {
 			filename = filepath.Base(scp.dstFile)
 		} else {
 			filename = rcvFilename
@@ -231,14 +304,47 @@ func (scp *SecureCopier) handleDefault(scanner *bufio.Scanner, cmd byte, first b
 			ce <- err
 			return
 		}
+		skip := st.skipDepth > 0
+		if !skip && !st.first && scp.IsRecursive {
+			skip = scp.isFiltered(st.relPath(rcvFilename), cmd == 'D')
+			if skip && scp.IsVerbose {
+				fmt.Fprintln(scp.errPipe, "Skipping filtered: "+st.relPath(rcvFilename))
+			}
+		}
+		if !skip && cmd == 'C' && scp.completed[filepath.Join(st.dstDir, filename)] {
+			skip = true
+			if scp.IsVerbose {
+				fmt.Fprintln(scp.errPipe, "Skipping completed: "+filepath.Join(st.dstDir, filename))
+			}
+		}
+		if skip {
+			if cmd == 'D' {
+				st.skipDepth++
+				return
+			}
+			// drain the file body and its trailing status byte
+			_, err = io.CopyN(ioutil.Discard, r, size+1)
+			if err != nil {
+				fmt.Fprintln(scp.errPipe, "Read error: "+err.Error())
+				ce <- err
+				return
+			}
+			err = sendByte(cw, 0)
+			if err != nil {
+				fmt.Fprintln(scp.errPipe, "Send null-byte error: "+err.Error())
+				ce <- err
+			}
+			return
+		}
 		if cmd == 'C' {
 			// C command - file
-			thisDstFile := filepath.Join(dstDir, filename)
+			thisDstFile := filepath.Join(st.dstDir, filename)
 			if scp.IsVerbose {
 				fmt.Fprintln(scp.errPipe, "Creating destination file: ", thisDstFile)
 			}
 			tot := int64(0)
-			pb := NewProgressBarTo(filename, size, scp.outPipe)
+			pb := NewProgressBarTo(filename, size, scp.progressOut())
+			pb.Job = scp.job
 			pb.Update(0)
 
 			fw, err := os.Create(thisDstFile)
@@ -249,15 +355,17 @@ func (scp *SecureCopier) handleDefault(scanner *bufio.Scanner, cmd byte, first b
 			}
 			defer fw.Close()
 
-			// buffered by 4096 bytes
-			bufferSize := int64(4096)
-			lastPercent := int64(0)
+			body := scp.limitReader(r)
+			buf := getBuffer(scp.bufferSize())
+			defer putBuffer(buf)
+			holes := int64(0)
 			for tot < size {
-				if bufferSize > size-tot {
-					bufferSize = size - tot
+				// fill the buffer before writing, the remote's packets are small
+				b := *buf
+				if int64(len(b)) > size-tot {
+					b = b[:size-tot]
 				}
-				b := make([]byte, bufferSize)
-				n, err = r.Read(b)
+				n, err := io.ReadFull(body, b)
 				if err != nil {
 					fmt.Fprintln(scp.errPipe, "Read error: "+err.Error())
 					ce <- err
@@ -265,17 +373,32 @@ func (scp *SecureCopier) handleDefault(scanner *bufio.Scanner, cmd byte, first b
 				}
 				tot += int64(n)
 				// write to file
-				_, err = fw.Write(b[:n])
+				if scp.Sparse {
+					var hole int64
+					hole, err = writeSparseBlocks(fw, b[:n])
+					holes += hole
+				} else {
+					_, err = fw.Write(b[:n])
+				}
 				if err != nil {
 					fmt.Fprintln(scp.errPipe, "Write error: "+err.Error())
 					ce <- err
 					return
 				}
-				percent := (100 * tot) / size
-				if percent > lastPercent {
-					pb.Update(tot)
+				pb.Update(tot)
+			}
+			if holes > 0 {
+				// a trailing hole leaves the file short until truncated
+				err = fw.Truncate(size)
+				if err != nil {
+					fmt.Fprintln(scp.errPipe, "Truncate error: "+err.Error())
+					ce <- err
+					return
 				}
-				lastPercent = percent
+			}
+			if scp.Sparse {
+				scp.sparseBytes += holes
+				scp.sparseTotal += size
 			}
 			// close file writer & check error
 			err = fw.Close()
@@ -285,8 +408,7 @@ func (scp *SecureCopier) handleDefault(scanner *bufio.Scanner, cmd byte, first b
 				return
 			}
 			// get next byte from channel reader
-			nb := make([]byte, 1)
-			_, err = r.Read(nb)
+			_, err = r.Read((*buf)[:1])
 			if err != nil {
 				fmt.Fprintln(scp.errPipe, err.Error())
 				ce <- err
@@ -299,12 +421,11 @@ func (scp *SecureCopier) handleDefault(scanner *bufio.Scanner, cmd byte, first b
 				ce <- err
 				return
 			}
-			pb.Update(tot)
-			// new line
-			fmt.Fprintln(scp.errPipe)
+			scp.markCompleted(thisDstFile)
+			pb.Finish()
 		} else {
 			// D command (directory)
-			thisDstFile := filepath.Join(dstDir, filename)
+			thisDstFile := filepath.Join(st.dstDir, filename)
 			fileMode := os.FileMode(uint32(mode))
 			err = os.MkdirAll(thisDstFile, fileMode)
 			if err != nil {
@@ -312,7 +433,10 @@ func (scp *SecureCopier) handleDefault(scanner *bufio.Scanner, cmd byte, first b
 				ce <- err
 				return
 			}
-			dstDir = thisDstFile
+			st.dstDir = thisDstFile
+			if !st.first {
+				st.dirs = append(st.dirs, rcvFilename)
+			}
 		}
 	default:
 		fmt.Fprintf(scp.errPipe, "Command '%v' NOT implemented\n", cmd)
@@ -0,0 +1,170 @@
+// Copyright © 2017 Ricardo Aravena <raravena@branch.io>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package scp
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// fakeSource Plays the remote 'scp -f' side, waiting for an ack before each record
+func fakeSource(t *testing.T, records []string, w io.WriteCloser, acks io.Reader) {
+	defer w.Close()
+	ack := make([]byte, 1)
+	for _, rec := range records {
+		if _, err := acks.Read(ack); err != nil {
+			t.Errorf("Reading ack: %v", err)
+			return
+		}
+		if _, err := w.Write([]byte(rec)); err != nil {
+			t.Errorf("Writing record: %v", err)
+			return
+		}
+	}
+	// final ack for the last record
+	acks.Read(ack)
+}
+
+func listTree(t *testing.T, root string) []string {
+	var found []string
+	filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(root, path)
+		if info.IsDir() {
+			rel += "/"
+		}
+		found = append(found, filepath.ToSlash(rel))
+		return nil
+	})
+	sort.Strings(found)
+	return found
+}
+
+func TestReceiveFiltered(t *testing.T) {
+	tests := []struct {
+		name     string
+		include  []string
+		exclude  []string
+		records  []string
+		expected []string
+	}{
+		{name: "Exclude subtree and file pattern",
+			exclude: []string{"skip", "*.log"},
+			records: []string{
+				"D0755 0 top\n",
+				"C0644 5 a.txt\n", "hello\x00",
+				"C0644 3 b.log\n", "log\x00",
+				"D0755 0 skip\n",
+				"C0644 4 c.txt\n", "data\x00",
+				"D0755 0 deeper\n",
+				"C0644 2 d.txt\n", "hi\x00",
+				"E\n",
+				"E\n",
+				"D0755 0 keep\n",
+				"C0644 2 e.txt\n", "ok\x00",
+				"E\n",
+				"E\n",
+			},
+			expected: []string{"./", "a.txt", "keep/", "keep/e.txt"},
+		},
+		{name: "Include only text files",
+			include: []string{"*.txt"},
+			records: []string{
+				"D0755 0 top\n",
+				"C0644 3 b.log\n", "log\x00",
+				"D0755 0 sub\n",
+				"C0644 4 c.txt\n", "data\x00",
+				"E\n",
+				"E\n",
+			},
+			expected: []string{"./", "sub/", "sub/c.txt"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "scpgo")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+			copier := NewSecureCopier()
+			copier.outPipe = ioutil.Discard
+			copier.errPipe = ioutil.Discard
+			copier.IsRecursive = true
+			copier.Include = tt.include
+			copier.Exclude = tt.exclude
+			copier.dstFile = filepath.Join(dir, "out")
+
+			stream, remote := io.Pipe()
+			acks, cw := io.Pipe()
+			go fakeSource(t, tt.records, remote, acks)
+			ce := make(chan error, 1)
+			copier.receive(cw, stream, dir, true, ce)
+			select {
+			case err := <-ce:
+				t.Fatalf("Unexpected error: %v", err)
+			default:
+			}
+			returned := listTree(t, copier.dstFile)
+			if !reflect.DeepEqual(returned, tt.expected) {
+				t.Errorf("Value received: %v expected %v", returned, tt.expected)
+			}
+			b, err := ioutil.ReadFile(filepath.Join(copier.dstFile, strings.TrimSuffix(tt.expected[len(tt.expected)-1], "/")))
+			if err != nil || len(b) == 0 {
+				t.Errorf("Expected content in last file, got %q (%v)", b, err)
+			}
+		})
+	}
+}
+
+func TestIsFiltered(t *testing.T) {
+	tests := []struct {
+		name     string
+		include  []string
+		exclude  []string
+		relPath  string
+		isDir    bool
+		expected bool
+	}{
+		{name: "No patterns", relPath: "a/b.txt", expected: false},
+		{name: "Exclude base name", exclude: []string{"*.log"}, relPath: "a/b.log", expected: true},
+		{name: "Exclude relative path", exclude: []string{"a/*"}, relPath: "a/b.txt", expected: true},
+		{name: "Include miss", include: []string{"*.go"}, relPath: "a/b.txt", expected: true},
+		{name: "Include hit", include: []string{"*.go"}, relPath: "a/b.go", expected: false},
+		{name: "Include ignores dirs", include: []string{"*.go"}, relPath: "a", isDir: true, expected: false},
+		{name: "Exclude dir", exclude: []string{"vendor"}, relPath: "x/vendor", isDir: true, expected: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			copier := NewSecureCopier()
+			copier.Include = tt.include
+			copier.Exclude = tt.exclude
+			returned := copier.isFiltered(tt.relPath, tt.isDir)
+			if returned != tt.expected {
+				t.Errorf("Value received: %v expected %v", returned, tt.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,162 @@
+// Copyright © 2017 Ricardo Aravena <raravena@branch.io>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package scp
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// JobProgress Tracks a whole recursive copy. Files and Size are the totals
+// a scan counted beforehand, zero when they aren't known.
+type JobProgress struct {
+	mu        sync.Mutex
+	StartTime time.Time
+	Files     int
+	Size      int64
+	doneFiles int
+	doneBytes int64
+}
+
+// NewJobProgress Instantiates the progress of a job of files and size bytes
+func NewJobProgress(files int, size int64) *JobProgress {
+	return &JobProgress{StartTime: time.Now(), Files: files, Size: size}
+}
+
+// FileDone Counts a file of size bytes as sent or received
+func (jp *JobProgress) FileDone(size int64) {
+	if jp == nil {
+		return
+	}
+	jp.mu.Lock()
+	defer jp.mu.Unlock()
+	jp.doneFiles++
+	jp.doneBytes += size
+}
+
+// Status Returns the job's line, with current bytes of the file in flight
+func (jp *JobProgress) Status(current int64) string {
+	jp.mu.Lock()
+	defer jp.mu.Unlock()
+	tot := jp.doneBytes + current
+	totTime := time.Now().Sub(jp.StartTime)
+	rate := 0.0
+	if totTime > 0 {
+		rate = float64(tot) / totTime.Seconds()
+	}
+	if jp.Files == 0 {
+		// downloads: the remote only announces each file as it comes
+		return fmt.Sprintf("| %d files %s %s/s", jp.doneFiles, humanBytes(tot), humanBytes(int64(rate)))
+	}
+	percent := int64(100)
+	if jp.Size > 0 {
+		percent = (int64(100) * tot) / jp.Size
+	}
+	eta := "--:--"
+	if tot >= jp.Size {
+		eta = formatDuration(0)
+	} else if tot > 0 {
+		eta = formatDuration(time.Duration(float64(totTime) * float64(jp.Size-tot) / float64(tot)))
+	}
+	return fmt.Sprintf("| %d/%d files %3d%% %s %s/s %s ETA", jp.doneFiles, jp.Files, percent, humanBytes(tot), humanBytes(int64(rate)), eta)
+}
+
+// scanTotals Counts the files and bytes a recursive upload of dirPath will
+// send, following the same filters, link handling and --max-depth as the
+// walk. Anything that can't be read is left for the walk to report.
+func (scp *SecureCopier) scanTotals(dirPath string, dirInfo os.FileInfo) (int, int64) {
+	return scp.scanDir(dirPath, []os.FileInfo{dirInfo})
+}
+
+func (scp *SecureCopier) scanDir(dirPath string, parents []os.FileInfo) (int, int64) {
+	if scp.MaxDepth > 0 && len(parents) > scp.MaxDepth {
+		return 0, 0
+	}
+	dir, err := os.Open(dirPath)
+	if err != nil {
+		return 0, 0
+	}
+	defer dir.Close()
+	files, size := 0, int64(0)
+	for {
+		fis, err := dir.Readdir(walkBatch)
+		for _, fi := range fis {
+			path := filepath.Join(dirPath, fi.Name())
+			fi = scp.scanEntry(path, fi, parents)
+			if fi == nil {
+				continue
+			}
+			if fi.IsDir() {
+				n, s := scp.scanDir(path, append(parents, fi))
+				files += n
+				size += s
+				continue
+			}
+			files++
+			size += fi.Size()
+		}
+		if err != nil {
+			return files, size
+		}
+	}
+}
+
+// scanEntry Returns what the walk would send for an entry, nil for nothing.
+// Unlike resolveLink and handleSpecial it neither warns nor reads FIFOs.
+func (scp *SecureCopier) scanEntry(path string, fi os.FileInfo, parents []os.FileInfo) os.FileInfo {
+	rel, relErr := filepath.Rel(scp.srcFile, path)
+	if relErr == nil && scp.isFiltered(rel, true) {
+		return nil
+	}
+	var err error
+	if fi.Mode()&os.ModeSymlink != 0 {
+		switch scp.Links {
+		case LinksSkip:
+			return nil
+		case LinksCopyAsFile:
+			target, err := os.Readlink(path)
+			if err != nil {
+				return nil
+			}
+			fi = linkFileInfo{fi, target}
+		default:
+			fi, err = os.Stat(path)
+			if err != nil {
+				return nil
+			}
+			if fi.IsDir() {
+				for _, parent := range parents {
+					if os.SameFile(parent, fi) {
+						return nil
+					}
+				}
+			}
+		}
+	}
+	if relErr == nil && scp.isFiltered(rel, fi.IsDir()) {
+		return nil
+	}
+	if isSpecial(fi) {
+		if fi.Mode()&os.ModeNamedPipe == 0 || !scp.ReadFifos {
+			return nil
+		}
+		// its size is only known once read
+		return spooledFileInfo{FileInfo: fi}
+	}
+	return fi
+}
@@ -0,0 +1,162 @@
+// Copyright © 2017 Ricardo Aravena <raravena@branch.io>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package scp
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestJobProgressStatus(t *testing.T) {
+	tests := []struct {
+		name     string
+		files    int
+		size     int64
+		done     []int64
+		inFlight int64
+		contains []string
+	}{
+		{name: "Half way",
+			files:    4,
+			size:     4000,
+			done:     []int64{1000, 1000},
+			contains: []string{"2/4 files", " 50%", "2.0 KiB", "00:01 ETA"},
+		},
+		{name: "File in flight",
+			files:    4,
+			size:     4000,
+			done:     []int64{1000},
+			inFlight: 2000,
+			contains: []string{"1/4 files", " 75%", "2.9 KiB"},
+		},
+		{name: "Nothing sent yet",
+			files:    2,
+			size:     100,
+			contains: []string{"0/2 files", "  0%", "0 B", "--:-- ETA"},
+		},
+		{name: "Only empty files",
+			files:    1,
+			done:     []int64{0},
+			contains: []string{"1/1 files", "100%", "00:00 ETA"},
+		},
+		{name: "Running total",
+			done:     []int64{1000, 2000, 3000},
+			contains: []string{"| 3 files 5.9 KiB"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			jp := NewJobProgress(tt.files, tt.size)
+			jp.StartTime = time.Now().Add(-time.Second)
+			for _, size := range tt.done {
+				jp.FileDone(size)
+			}
+			returned := jp.Status(tt.inFlight)
+			for _, expected := range tt.contains {
+				if !strings.Contains(returned, expected) {
+					t.Errorf("Value received: %q expected to contain %q", returned, expected)
+				}
+			}
+		})
+	}
+}
+
+func TestProgressBarFinish(t *testing.T) {
+	var out bytes.Buffer
+	pb := NewProgressBarTo("f", 3000, &out)
+	pb.Width = 200
+	pb.Job = NewJobProgress(2, 4000)
+	pb.Update(1500)
+	if !strings.Contains(out.String(), "0/2 files") {
+		t.Errorf("Value received: %q expected 0/2 files", out.String())
+	}
+	out.Reset()
+	// counted once, not again as in flight
+	pb.Finish()
+	if !strings.Contains(out.String(), "1/2 files  75%") {
+		t.Errorf("Value received: %q expected 1/2 files at 75 %%", out.String())
+	}
+	// without a job there is nothing to count
+	pb.Job = nil
+	pb.Finish()
+}
+
+func TestScanTotals(t *testing.T) {
+	root, err := ioutil.TempDir("", "scpgo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	os.MkdirAll(filepath.Join(root, "a", "b"), 0755)
+	ioutil.WriteFile(filepath.Join(root, "top.txt"), []byte("12345"), 0644)
+	ioutil.WriteFile(filepath.Join(root, "skip.log"), []byte("123"), 0644)
+	ioutil.WriteFile(filepath.Join(root, "a", "mid.txt"), []byte("1234567"), 0644)
+	ioutil.WriteFile(filepath.Join(root, "a", "b", "deep.txt"), []byte("12"), 0644)
+	os.Symlink("top.txt", filepath.Join(root, "link"))
+	os.Symlink(".", filepath.Join(root, "a", "loop"))
+
+	tests := []struct {
+		name     string
+		exclude  []string
+		links    string
+		maxDepth int
+		files    int
+		size     int64
+	}{
+		{name: "Everything", files: 5, size: 22},
+		{name: "Filtered", exclude: []string{"*.log"}, files: 4, size: 19},
+		{name: "Links skipped", links: LinksSkip, files: 4, size: 17},
+		{name: "Links as files", links: LinksCopyAsFile, files: 6, size: 17 + 7 + 1},
+		{name: "Top level only", maxDepth: 1, files: 3, size: 13},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			copier := NewSecureCopier()
+			copier.Exclude = tt.exclude
+			copier.Links = tt.links
+			copier.MaxDepth = tt.maxDepth
+			copier.srcFile = root
+			fi, err := os.Stat(root)
+			if err != nil {
+				t.Fatal(err)
+			}
+			files, size := copier.scanTotals(root, fi)
+			if files != tt.files || size != tt.size {
+				t.Errorf("Value received: %v, %v expected %v, %v", files, size, tt.files, tt.size)
+			}
+			// the scan has to agree with what the walk sends
+			stream, _ := uploadTree(t, &copier, root)
+			sent, sentSize := 0, int64(0)
+			for _, rec := range records(stream) {
+				if strings.HasPrefix(rec, "C") {
+					n, _ := strconv.ParseInt(strings.Fields(rec)[1], 10, 64)
+					sent++
+					sentSize += n
+				}
+			}
+			if files != sent || size != sentSize {
+				t.Errorf("Value received: %v, %v expected the walk's %v, %v", files, size, sent, sentSize)
+			}
+		})
+	}
+}
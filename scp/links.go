@@ -0,0 +1,86 @@
+// Copyright © 2017 Ricardo Aravena <raravena@branch.io>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package scp
+
+import (
+	"fmt"
+	"os"
+)
+
+const (
+	// LinksFollow Sends what symlinks point to (the default, like OpenSSH scp)
+	LinksFollow = "follow"
+	// LinksSkip Leaves symlinks out of recursive uploads
+	LinksSkip = "skip"
+	// LinksCopyAsFile Sends a symlink as a regular file holding its target path
+	LinksCopyAsFile = "copy-as-file"
+	// LinksPreserve Recreates symlinks remotely, only possible over SFTP
+	LinksPreserve = "preserve"
+)
+
+// checkLinksMode Validates the --links option for the scp backend
+func checkLinksMode(mode string) error {
+	switch mode {
+	case "", LinksFollow, LinksSkip, LinksCopyAsFile:
+		return nil
+	case LinksPreserve:
+		return fmt.Errorf("--links=%s needs the SFTP backend, the scp protocol cannot carry symlinks", mode)
+	}
+	return fmt.Errorf("Unknown --links mode '%s' (use %s, %s or %s)", mode, LinksFollow, LinksSkip, LinksCopyAsFile)
+}
+
+// linkFileInfo Describes a symlink sent as a file containing its target
+type linkFileInfo struct {
+	os.FileInfo
+	target string
+}
+
+func (li linkFileInfo) Size() int64 {
+	return int64(len(li.target))
+}
+
+func (li linkFileInfo) Mode() os.FileMode {
+	return li.FileInfo.Mode() &^ os.ModeSymlink
+}
+
+// resolveLink Decides how a symlink met during a recursive upload is sent.
+// It returns a nil FileInfo when the link should be left out.
+func (scp *SecureCopier) resolveLink(path string, fi os.FileInfo) (os.FileInfo, error) {
+	switch scp.Links {
+	case LinksSkip:
+		scp.warn("Skipping symlink %s", path)
+		return nil, nil
+	case LinksCopyAsFile:
+		target, err := os.Readlink(path)
+		if err != nil {
+			return nil, err
+		}
+		return linkFileInfo{fi, target}, nil
+	}
+	target, err := os.Stat(path)
+	if err != nil {
+		scp.warn("Skipping broken symlink %s (%v)", path, err)
+		return nil, nil
+	}
+	if target.IsDir() {
+		for _, ancestor := range scp.dirStack {
+			if os.SameFile(ancestor, target) {
+				scp.warn("Skipping symlink %s: it loops back to a parent directory", path)
+				return nil, nil
+			}
+		}
+	}
+	return target, nil
+}
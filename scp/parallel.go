@@ -0,0 +1,316 @@
+// Copyright © 2017 Ricardo Aravena <raravena@branch.io>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package scp
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/raravena80/scpgo/sshconn"
+	"golang.org/x/crypto/ssh"
+)
+
+// uploadDir A directory on the way from the upload root to a file
+type uploadDir struct {
+	name string
+	mode os.FileMode
+}
+
+// uploadJob One file of a parallel upload and the directories below the
+// root that lead to it
+type uploadJob struct {
+	path string
+	fi   os.FileInfo
+	dirs []uploadDir
+}
+
+// uploadPlan The files the walk of a parallel upload found, in walk order,
+// and the remote directories that only get their mode once they are filled
+type uploadPlan struct {
+	jobs  []uploadJob
+	modes map[string]os.FileMode
+}
+
+// addDir Notes a directory the sessions couldn't write to with its own mode
+func (plan *uploadPlan) addDir(root string, dirs []uploadDir) {
+	mode := dirs[len(dirs)-1].mode.Perm()
+	if mode&0700 == 0700 {
+		return
+	}
+	remotePath := root
+	for _, dir := range dirs[1:] {
+		remotePath = path.Join(remotePath, dir.name)
+	}
+	if plan.modes == nil {
+		plan.modes = map[string]os.FileMode{}
+	}
+	plan.modes[remotePath] = mode
+}
+
+func (plan *uploadPlan) add(path string, fi os.FileInfo, dirs []uploadDir) {
+	// the first directory is the root the sessions start in
+	plan.jobs = append(plan.jobs, uploadJob{
+		path: path,
+		fi:   fi,
+		dirs: append([]uploadDir{}, dirs[1:]...),
+	})
+}
+
+// lockedWriter Keeps the lines of concurrent sessions from interleaving
+type lockedWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (lw *lockedWriter) Write(p []byte) (int, error) {
+	lw.mu.Lock()
+	defer lw.mu.Unlock()
+	return lw.w.Write(p)
+}
+
+// batchProgress One progress bar for all the files of a parallel upload
+type batchProgress struct {
+	mu    sync.Mutex
+	pb    ProgressBar
+	files int
+	done  int
+	bytes int64
+}
+
+func newBatchProgress(jobs []uploadJob, outPipe io.Writer) *batchProgress {
+	size := int64(0)
+	for _, job := range jobs {
+		size += job.fi.Size()
+	}
+	p := &batchProgress{pb: NewProgressBarTo("", size, outPipe), files: len(jobs)}
+	p.update()
+	return p
+}
+
+// add Counts a file one of the sessions is done with
+func (p *batchProgress) add(size int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.done++
+	p.bytes += size
+	p.update()
+}
+
+func (p *batchProgress) update() {
+	p.pb.Subject = fmt.Sprintf("%d/%d files", p.done, p.files)
+	p.pb.Update(p.bytes)
+}
+
+// finish Ends the bar's line once the sessions are done
+func (p *batchProgress) finish() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.pb.Subject = fmt.Sprintf("%d/%d files", p.done, p.files)
+	p.pb.end(p.bytes)
+}
+
+// newWorker Returns a copy of the copier to run one session of a parallel
+// upload. It gets its own acks and results, merged back by mergeWorker.
+func (scp *SecureCopier) newWorker(errPipe io.Writer) *SecureCopier {
+	worker := *scp
+	worker.worker = true
+	worker.outPipe = ioutil.Discard
+	worker.errPipe = errPipe
+	worker.acks = nil
+	worker.completed = map[string]bool{}
+	for file := range scp.completed {
+		worker.completed[file] = true
+	}
+	worker.changedFiles = nil
+	worker.sparseBytes = 0
+	worker.sparseTotal = 0
+	worker.walkErrors = nil
+	return &worker
+}
+
+func (scp *SecureCopier) mergeWorker(worker *SecureCopier) {
+	for file := range worker.completed {
+		scp.markCompleted(file)
+	}
+	scp.changedFiles = append(scp.changedFiles, worker.changedFiles...)
+	scp.sparseBytes += worker.sparseBytes
+	scp.sparseTotal += worker.sparseTotal
+	for file := range worker.walkErrors {
+		if scp.walkErrors == nil {
+			scp.walkErrors = map[string]bool{}
+		}
+		scp.walkErrors[file] = true
+	}
+}
+
+// uploadParallel Sends a directory over Parallel sessions of one client.
+// One session creates the directories first, in walk order, and collects
+// the files. The others then share the files, each stepping in and out of
+// the existing directories as it goes.
+func (scp *SecureCopier) uploadParallel(client *ssh.Client, monitor *sshconn.Monitor, srcFileInfo os.FileInfo) error {
+	session, err := client.NewSession()
+	if err != nil {
+		return err
+	}
+	plan := &uploadPlan{}
+	scp.plan = plan
+	err = scp.runSink(session, monitor, path.Dir(scp.remoteRoot), func(procWriter io.Writer) error {
+		return scp.processDir(procWriter, scp.srcFile, srcFileInfo)
+	})
+	scp.plan = nil
+	if err != nil {
+		return err
+	}
+	if len(plan.jobs) == 0 {
+		return nil
+	}
+
+	sessions := []*ssh.Session{}
+	for i := 0; i < scp.Parallel && i < len(plan.jobs); i++ {
+		session, err := client.NewSession()
+		if err != nil && len(sessions) > 0 {
+			// like MaxSessions in sshd
+			scp.warn("Server refused session %d (%v), going on with %d", i+1, err, len(sessions))
+			break
+		}
+		if err != nil {
+			return err
+		}
+		sessions = append(sessions, session)
+	}
+	if scp.IsVerbose {
+		fmt.Fprintf(scp.errPipe, "Sending %d file(s) over %d sessions\n", len(plan.jobs), len(sessions))
+	}
+	// all queued up front, a failed session leaves its share to the others
+	jobs := make(chan uploadJob, len(plan.jobs))
+	for _, job := range plan.jobs {
+		jobs <- job
+	}
+	close(jobs)
+	progress := newBatchProgress(plan.jobs, scp.progressOut())
+	errPipe := &lockedWriter{w: scp.errPipe}
+	workers := make([]*SecureCopier, len(sessions))
+	errs := make([]error, len(sessions))
+	var wg sync.WaitGroup
+	for i, session := range sessions {
+		workers[i] = scp.newWorker(errPipe)
+		wg.Add(1)
+		go func(i int, session *ssh.Session) {
+			defer wg.Done()
+			worker := workers[i]
+			errs[i] = worker.runSink(session, monitor, scp.remoteRoot, func(procWriter io.Writer) error {
+				return worker.sendJobs(procWriter, jobs, progress)
+			})
+		}(i, session)
+	}
+	wg.Wait()
+	progress.finish()
+
+	failed := 0
+	for i, worker := range workers {
+		scp.mergeWorker(worker)
+		if errs[i] != nil {
+			if err == nil {
+				err = errs[i]
+			}
+			failed++
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("%d of %d sessions failed: %w", failed, len(sessions), err)
+	}
+	return scp.restoreModes(client, plan.modes)
+}
+
+// restoreModes Takes the owner's write access away again from directories
+// that didn't have it, now that their files are in
+func (scp *SecureCopier) restoreModes(client *ssh.Client, modes map[string]os.FileMode) error {
+	if len(modes) == 0 {
+		return nil
+	}
+	dirs := []string{}
+	for dir := range modes {
+		dirs = append(dirs, dir)
+	}
+	// children first, a parent without x would hide them
+	sort.Sort(sort.Reverse(sort.StringSlice(dirs)))
+	cmds := []string{}
+	for _, dir := range dirs {
+		cmds = append(cmds, fmt.Sprintf("chmod %04o %s", modes[dir], shellQuote(dir)))
+	}
+	session, err := client.NewSession()
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+	if scp.IsVerbose {
+		fmt.Fprintf(scp.errPipe, "Restoring the mode of %d directories\n", len(cmds))
+	}
+	return session.Run(strings.Join(cmds, " && "))
+}
+
+// shellQuote Quotes s for the remote shell, leaving a leading ~/ for it to
+// expand as it does in the remote scp's target
+func shellQuote(s string) string {
+	if strings.HasPrefix(s, "~/") {
+		return "~/" + shellQuote(s[2:])
+	}
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+// sendJobs Sends files from jobs until there are none left, moving between
+// the directories the first session created
+func (scp *SecureCopier) sendJobs(procWriter io.Writer, jobs <-chan uploadJob, progress *batchProgress) error {
+	var cur []uploadDir
+	for job := range jobs {
+		// climb out of what job doesn't share, then down to its directory
+		common := 0
+		for common < len(cur) && common < len(job.dirs) && cur[common].name == job.dirs[common].name {
+			common++
+		}
+		for len(cur) > common {
+			err := scp.sendEndDir(procWriter)
+			if err != nil {
+				return err
+			}
+			cur = cur[:len(cur)-1]
+		}
+		for _, dir := range job.dirs[common:] {
+			err := scp.sendDirRecord(procWriter, dir.name, dir.mode)
+			if err != nil {
+				return err
+			}
+			cur = append(cur, dir)
+		}
+		err := scp.sendFile(procWriter, job.path, job.fi)
+		if err != nil {
+			return err
+		}
+		progress.add(job.fi.Size())
+	}
+	for range cur {
+		err := scp.sendEndDir(procWriter)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
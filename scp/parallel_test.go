@@ -0,0 +1,181 @@
+// Copyright © 2017 Ricardo Aravena <raravena@branch.io>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package scp
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"testing"
+)
+
+// records Returns the D, C and E records of an upload stream without the
+// file bodies
+func records(stream string) []string {
+	re := regexp.MustCompile(`(?:^|[\n\x00])(D\d{4} 0 [^\n]+|C\d{4} \d+ [^\n]+|E)`)
+	found := []string{}
+	for _, match := range re.FindAllStringSubmatch(stream, -1) {
+		found = append(found, match[1])
+	}
+	return found
+}
+
+func TestUploadPlan(t *testing.T) {
+	root, err := ioutil.TempDir("", "scpgo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	os.Chmod(root, 0755)
+	os.MkdirAll(filepath.Join(root, "a", "b"), 0755)
+	ioutil.WriteFile(filepath.Join(root, "a", "b", "deep.txt"), []byte("deep"), 0644)
+
+	copier := NewSecureCopier()
+	copier.remoteRoot = "/srv/up"
+	copier.plan = &uploadPlan{}
+	stream, _ := uploadTree(t, &copier, root)
+
+	// only the directories go out, the files are left to the sessions
+	expected := []string{"D0755 0 up", "D0755 0 a", "D0755 0 b", "E", "E", "E"}
+	got := records(stream)
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("Value received: %v expected %v", got, expected)
+	}
+	if len(copier.plan.jobs) != 1 {
+		t.Fatalf("Value received: %v expected %v", len(copier.plan.jobs), 1)
+	}
+	dirs := copier.plan.jobs[0].dirs
+	if len(dirs) != 2 || dirs[0].name != "a" || dirs[1].name != "b" {
+		t.Errorf("Value received: %v expected a/b", dirs)
+	}
+}
+
+func TestUploadPlanModes(t *testing.T) {
+	plan := &uploadPlan{}
+	root := uploadDir{name: "src", mode: os.ModeDir | 0755}
+	plan.addDir("/srv/up", []uploadDir{root})
+	plan.addDir("/srv/up", []uploadDir{root, {name: "a", mode: os.ModeDir | 0755}})
+	plan.addDir("/srv/up", []uploadDir{root, {name: "a", mode: os.ModeDir | 0755}, {name: "locked", mode: os.ModeDir | 0555}})
+	modes := map[string]os.FileMode{"/srv/up/a/locked": 0555}
+	if !reflect.DeepEqual(plan.modes, modes) {
+		t.Errorf("Value received: %v expected %v", plan.modes, modes)
+	}
+}
+
+func TestSendJobs(t *testing.T) {
+	root, err := ioutil.TempDir("", "scpgo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	file := filepath.Join(root, "f")
+	ioutil.WriteFile(file, []byte("x"), 0644)
+	fi, err := os.Stat(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := func(name string) uploadDir { return uploadDir{name: name, mode: 0755} }
+
+	tests := []struct {
+		name     string
+		dirs     [][]uploadDir
+		expected []string
+	}{
+		{name: "Files in the root",
+			dirs:     [][]uploadDir{nil, nil},
+			expected: []string{"C0644 1 f", "C0644 1 f"},
+		},
+		{name: "Stays in a shared directory",
+			dirs:     [][]uploadDir{{dir("a")}, {dir("a")}},
+			expected: []string{"D0755 0 a", "C0644 1 f", "C0644 1 f", "E"},
+		},
+		{name: "Moves between siblings",
+			dirs: [][]uploadDir{{dir("a"), dir("b")}, {dir("a"), dir("c")}, nil},
+			expected: []string{"D0755 0 a", "D0755 0 b", "C0644 1 f", "E", "D0755 0 c", "C0644 1 f",
+				"E", "E", "C0644 1 f"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			jobs := make(chan uploadJob, len(tt.dirs))
+			planned := []uploadJob{}
+			for _, dirs := range tt.dirs {
+				job := uploadJob{path: file, fi: fi, dirs: dirs}
+				jobs <- job
+				planned = append(planned, job)
+			}
+			close(jobs)
+			copier := NewSecureCopier()
+			copier.outPipe = ioutil.Discard
+			copier.errPipe = ioutil.Discard
+			var stream bytes.Buffer
+			progress := newBatchProgress(planned, ioutil.Discard)
+			err := copier.newWorker(ioutil.Discard).sendJobs(&stream, jobs, progress)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			got := records(stream.String())
+			if !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("Value received: %v expected %v", got, tt.expected)
+			}
+			if progress.done != len(planned) {
+				t.Errorf("Value received: %v expected %v", progress.done, len(planned))
+			}
+		})
+	}
+}
+
+func TestMergeWorker(t *testing.T) {
+	copier := NewSecureCopier()
+	copier.markCompleted("done")
+	worker := copier.newWorker(ioutil.Discard)
+	worker.markCompleted("new")
+	worker.changedFiles = []string{"grew"}
+	if copier.completed["new"] {
+		t.Errorf("Expected the worker to keep its own completed files")
+	}
+	copier.mergeWorker(worker)
+	if !copier.completed["done"] || !copier.completed["new"] {
+		t.Errorf("Value received: %v expected done and new", copier.completed)
+	}
+	if !reflect.DeepEqual(copier.changedFiles, []string{"grew"}) {
+		t.Errorf("Value received: %v expected %v", copier.changedFiles, []string{"grew"})
+	}
+}
+
+func TestShellQuote(t *testing.T) {
+	tests := []struct {
+		name     string
+		path     string
+		expected string
+	}{
+		{name: "Quote", path: "it's here", expected: `'it'\''s here'`},
+		{name: "Home", path: "~/up dir", expected: `~/'up dir'`},
+		{name: "Other user's home", path: "~bob/up", expected: `'~bob/up'`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := shellQuote(tt.path)
+			if got != tt.expected {
+				t.Errorf("Value received: %v expected %v", got, tt.expected)
+			}
+		})
+	}
+}
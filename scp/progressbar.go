@@ -17,12 +17,28 @@ package scp
 import (
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
+	"strconv"
+	"strings"
 	"time"
+
+	"golang.org/x/crypto/ssh/terminal"
 )
 
-// DEFAULTFORMAT for progressbar
-const DEFAULTFORMAT = "\r%s   % 3d %%  %d kb %0.2f kb/s %v      "
+// DEFAULTFORMAT for progressbar: percent, size, rate and the time left or,
+// once done, taken
+const DEFAULTFORMAT = " %3d%% %10s %10s/s %9s"
+
+// rateSmoothing Weight of the newest sample in the moving average rate
+const rateSmoothing = 0.3
+
+const (
+	// minBar Narrowest bar worth drawing, below it only the numbers are shown
+	minBar = 10
+	// maxBar Widest bar, the rest of a wide terminal goes to the file name
+	maxBar = 40
+)
 
 // ProgressBar Struct for Progress Bar
 type ProgressBar struct {
@@ -31,11 +47,26 @@ type ProgressBar struct {
 	Subject   string
 	StartTime time.Time
 	Size      int64
+	// Job Whole-copy progress shown after the file's, when set
+	Job *JobProgress
+	// Width Columns of the terminal the bar is redrawn in. It is 0 when Out
+	// isn't a terminal, then only a plain line is printed once done.
+	Width    int
+	lastDraw time.Time
+	lastTot  int64
+	rate     float64
 }
 
 // NewProgressBarTo Instantiatiates a new Progress Bar To
 func NewProgressBarTo(subject string, size int64, outPipe io.Writer) ProgressBar {
-	return ProgressBar{outPipe, DEFAULTFORMAT, subject, time.Now(), size}
+	return ProgressBar{
+		Out:       outPipe,
+		Format:    DEFAULTFORMAT,
+		Subject:   subject,
+		StartTime: time.Now(),
+		Size:      size,
+		Width:     terminalWidth(outPipe),
+	}
 }
 
 // NewProgressBar Instantiatiates a new Progress Bar
@@ -43,15 +74,184 @@ func NewProgressBar(subject string, size int64) ProgressBar {
 	return NewProgressBarTo(subject, size, os.Stdout)
 }
 
-// Update Updates the Progress Bar
-func (pb ProgressBar) Update(tot int64) {
-	percent := int64(0)
-	if pb.Size > int64(0) {
-		percent = (int64(100) * tot) / pb.Size
+// terminalWidth Returns the columns of out, 0 when it isn't a terminal
+func terminalWidth(out io.Writer) int {
+	f, ok := out.(*os.File)
+	if !ok || !terminal.IsTerminal(int(f.Fd())) {
+		return 0
+	}
+	width, _, err := terminal.GetSize(int(f.Fd()))
+	if err == nil && width > 0 {
+		return width
+	}
+	width, err = strconv.Atoi(os.Getenv("COLUMNS"))
+	if err == nil && width > 0 {
+		return width
+	}
+	return 80
+}
+
+// Update Redraws the bar at tot bytes, a few times a second at most
+func (pb *ProgressBar) Update(tot int64) {
+	if pb.Width == 0 {
+		return
+	}
+	now := time.Now()
+	if !pb.lastDraw.IsZero() && now.Sub(pb.lastDraw) < progressInterval {
+		return
+	}
+	pb.sample(tot, now)
+	eta := "--:-- ETA"
+	if pb.rate > 0 && pb.Size > tot {
+		left := time.Duration(float64(pb.Size-tot) / pb.rate * float64(time.Second))
+		eta = formatDuration(left) + " ETA"
+	}
+	stats := fmt.Sprintf(pb.Format, pb.percent(tot), humanBytes(tot), humanBytes(int64(pb.rate)), eta)
+	fmt.Fprint(pb.Out, "\r"+pb.line(tot, stats, tot))
+}
+
+// Finish Shows the file as complete, with its average rate and the time it
+// took, and counts it in the job's totals
+func (pb *ProgressBar) Finish() {
+	pb.Job.FileDone(pb.Size)
+	pb.end(pb.Size)
+}
+
+// end Prints the last state of the bar at tot bytes and ends its line
+func (pb *ProgressBar) end(tot int64) {
+	elapsed := time.Now().Sub(pb.StartTime)
+	rate := 0.0
+	if elapsed > 0 {
+		rate = float64(tot) / elapsed.Seconds()
 	}
-	totTime := time.Now().Sub(pb.StartTime)
-	spd := float64(tot/1000) / totTime.Seconds()
-	//TODO put kb size into format string
-	fmt.Fprintf(pb.Out, pb.Format, pb.Subject, percent, tot, spd, totTime)
+	stats := fmt.Sprintf(pb.Format, pb.percent(tot), humanBytes(tot), humanBytes(int64(rate)), formatDuration(elapsed))
+	if pb.Width == 0 {
+		line := pb.Subject + stats
+		if pb.Job != nil {
+			line += " " + pb.Job.Status(0)
+		}
+		fmt.Fprintln(pb.Out, line)
+		return
+	}
+	fmt.Fprintln(pb.Out, "\r"+pb.line(tot, stats, 0))
+}
+
+// sample Folds the rate since the last redraw into the moving average
+func (pb *ProgressBar) sample(tot int64, now time.Time) {
+	since := pb.lastDraw
+	if since.IsZero() {
+		since = pb.StartTime
+	}
+	secs := now.Sub(since).Seconds()
+	if secs > 0 {
+		current := float64(tot-pb.lastTot) / secs
+		if pb.rate == 0 {
+			pb.rate = current
+		} else {
+			pb.rate = rateSmoothing*current + (1-rateSmoothing)*pb.rate
+		}
+	}
+	pb.lastDraw = now
+	pb.lastTot = tot
+}
+
+func (pb *ProgressBar) percent(tot int64) int64 {
+	if pb.Size <= 0 {
+		return 100
+	}
+	return (int64(100) * tot) / pb.Size
+}
 
+// line Fits the subject, the bar, stats and the job's status into the
+// terminal width, leaving the last column free so the line doesn't wrap.
+// The bar goes first when it's narrow, then the start of the subject.
+func (pb *ProgressBar) line(tot int64, stats string, inFlight int64) string {
+	tail := stats
+	if pb.Job != nil {
+		tail += " " + pb.Job.Status(inFlight)
+	}
+	room := pb.Width - 1 - len(tail)
+	if room < 4 {
+		return fitLeft(tail, pb.Width-1)
+	}
+	bar := ""
+	if barWidth := room / 3; barWidth >= minBar+2 {
+		if barWidth > maxBar+2 {
+			barWidth = maxBar + 2
+		}
+		bar = " " + drawBar(barWidth-2, pb.percent(tot))
+		room -= len(bar)
+	}
+	return fitLeft(pb.Subject, room) + bar + tail
+}
+
+// drawBar Returns a bar of width columns between brackets, percent full
+func drawBar(width int, percent int64) string {
+	if percent > 100 {
+		percent = 100
+	}
+	filled := int(int64(width) * percent / 100)
+	bar := strings.Repeat("=", filled)
+	if filled < width {
+		bar += ">" + strings.Repeat(" ", width-filled-1)
+	}
+	return "[" + bar + "]"
+}
+
+// fitLeft Pads s to width columns, or cuts its start off to keep the end of
+// a path, which says more
+func fitLeft(s string, width int) string {
+	r := []rune(s)
+	if len(r) <= width {
+		return s + strings.Repeat(" ", width-len(r))
+	}
+	if width <= 3 {
+		return string(r[len(r)-width:])
+	}
+	return "..." + string(r[len(r)-width+3:])
+}
+
+// humanBytes Formats n in B, KiB, MiB, GiB or TiB
+func humanBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit && exp < 3; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGT"[exp])
+}
+
+// formatDuration Formats d as mm:ss, or h:mm:ss from an hour on
+func formatDuration(d time.Duration) string {
+	secs := int64(d.Round(time.Second) / time.Second)
+	if secs >= 3600 {
+		return fmt.Sprintf("%d:%02d:%02d", secs/3600, secs/60%60, secs%60)
+	}
+	return fmt.Sprintf("%02d:%02d", secs/60, secs%60)
+}
+
+// progressWriter Moves a progress bar along with the bytes written
+type progressWriter struct {
+	w   io.Writer
+	pb  *ProgressBar
+	tot int64
+}
+
+func (pw *progressWriter) Write(p []byte) (int, error) {
+	n, err := pw.w.Write(p)
+	pw.tot += int64(n)
+	pw.pb.Update(pw.tot)
+	return n, err
+}
+
+// progressOut Returns where progress bars go, nowhere in quiet mode
+func (scp *SecureCopier) progressOut() io.Writer {
+	if scp.IsQuiet {
+		return ioutil.Discard
+	}
+	return scp.outPipe
 }
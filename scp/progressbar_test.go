@@ -15,8 +15,11 @@
 package scp
 
 import (
+	"bytes"
 	"io"
+	"io/ioutil"
 	"os"
+	"strings"
 	"testing"
 	"time"
 )
@@ -106,3 +109,193 @@ func TestNewProgressBarTo(t *testing.T) {
 		})
 	}
 }
+
+func TestHumanBytes(t *testing.T) {
+	tests := []struct {
+		name     string
+		n        int64
+		expected string
+	}{
+		{name: "Nothing", n: 0, expected: "0 B"},
+		{name: "Below a KiB", n: 1023, expected: "1023 B"},
+		{name: "One KiB", n: 1024, expected: "1.0 KiB"},
+		{name: "Fractions", n: 1536, expected: "1.5 KiB"},
+		{name: "MiB", n: 5 << 20, expected: "5.0 MiB"},
+		{name: "GiB", n: 3 << 30, expected: "3.0 GiB"},
+		{name: "TiB is the largest", n: 2048 << 40, expected: "2048.0 TiB"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			returned := humanBytes(tt.n)
+			if returned != tt.expected {
+				t.Errorf("Value received: %v expected %v", returned, tt.expected)
+			}
+		})
+	}
+}
+
+func TestFormatDuration(t *testing.T) {
+	tests := []struct {
+		name     string
+		d        time.Duration
+		expected string
+	}{
+		{name: "Nothing", d: 0, expected: "00:00"},
+		{name: "Rounded", d: 1500 * time.Millisecond, expected: "00:02"},
+		{name: "Minutes", d: 61 * time.Second, expected: "01:01"},
+		{name: "Hours", d: time.Hour + 2*time.Minute + 3*time.Second, expected: "1:02:03"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			returned := formatDuration(tt.d)
+			if returned != tt.expected {
+				t.Errorf("Value received: %v expected %v", returned, tt.expected)
+			}
+		})
+	}
+}
+
+func TestDrawBar(t *testing.T) {
+	tests := []struct {
+		name     string
+		width    int
+		percent  int64
+		expected string
+	}{
+		{name: "Empty", width: 10, percent: 0, expected: "[>         ]"},
+		{name: "Half", width: 10, percent: 50, expected: "[=====>    ]"},
+		{name: "Full", width: 10, percent: 100, expected: "[==========]"},
+		{name: "Overflowing", width: 4, percent: 150, expected: "[====]"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			returned := drawBar(tt.width, tt.percent)
+			if returned != tt.expected {
+				t.Errorf("Value received: %v expected %v", returned, tt.expected)
+			}
+		})
+	}
+}
+
+func TestFitLeft(t *testing.T) {
+	tests := []struct {
+		name     string
+		s        string
+		width    int
+		expected string
+	}{
+		{name: "Padded", s: "abc", width: 5, expected: "abc  "},
+		{name: "Keeps the end", s: "/very/long/path", width: 10, expected: "...ng/path"},
+		{name: "No room for dots", s: "abcdef", width: 2, expected: "ef"},
+		{name: "Counts runes", s: "héllo", width: 5, expected: "héllo"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			returned := fitLeft(tt.s, tt.width)
+			if returned != tt.expected {
+				t.Errorf("Value received: %v expected %v", returned, tt.expected)
+			}
+		})
+	}
+}
+
+func TestProgressBarWidth(t *testing.T) {
+	tests := []struct {
+		name  string
+		width int
+		tot   int64
+		job   bool
+		bar   bool
+	}{
+		{name: "Too narrow for the numbers", width: 20, tot: 500},
+		{name: "Too narrow for a bar", width: 45, tot: 500},
+		{name: "Standard terminal", width: 80, tot: 500, bar: true},
+		{name: "Complete", width: 80, tot: 1000, bar: true},
+		{name: "With the job's status", width: 80, tot: 500, job: true},
+		{name: "Wide terminal", width: 200, tot: 500, job: true, bar: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			pb := NewProgressBarTo("/a/rather/long/path/to/some/file.txt", 1000, &out)
+			pb.Width = tt.width
+			if tt.job {
+				pb.Job = NewJobProgress(3, 5000)
+			}
+			pb.Update(tt.tot)
+			line := strings.TrimPrefix(out.String(), "\r")
+			// the last column stays free, a full line would wrap
+			if len([]rune(line)) != tt.width-1 {
+				t.Errorf("Value received: %v expected %v (%q)", len([]rune(line)), tt.width-1, line)
+			}
+			if strings.Contains(line, "[") != tt.bar {
+				t.Errorf("Value received: %q expected a bar: %v", line, tt.bar)
+			}
+		})
+	}
+}
+
+func TestProgressBarThrottle(t *testing.T) {
+	var out bytes.Buffer
+	pb := NewProgressBarTo("f", 1000, &out)
+	pb.Width = 80
+	pb.Update(10)
+	pb.Update(20)
+	if strings.Count(out.String(), "\r") != 1 {
+		t.Errorf("Value received: %v expected %v", strings.Count(out.String(), "\r"), 1)
+	}
+	pb.lastDraw = pb.lastDraw.Add(-progressInterval)
+	pb.Update(30)
+	if strings.Count(out.String(), "\r") != 2 {
+		t.Errorf("Value received: %v expected %v", strings.Count(out.String(), "\r"), 2)
+	}
+}
+
+func TestProgressBarRate(t *testing.T) {
+	pb := NewProgressBarTo("f", 10000, ioutil.Discard)
+	start := pb.StartTime
+	pb.sample(1000, start.Add(time.Second))
+	if pb.rate != 1000 {
+		t.Errorf("Value received: %v expected %v", pb.rate, 1000)
+	}
+	// a burst only moves the average part of the way
+	pb.sample(4000, start.Add(2*time.Second))
+	if pb.rate != 1600 {
+		t.Errorf("Value received: %v expected %v", pb.rate, 1600)
+	}
+}
+
+func TestProgressBarPlain(t *testing.T) {
+	var out bytes.Buffer
+	pb := NewProgressBarTo("f", 2048, &out)
+	if pb.Width != 0 {
+		t.Fatalf("Value received: %v expected %v", pb.Width, 0)
+	}
+	pb.Update(1024)
+	if out.Len() != 0 {
+		t.Errorf("Expected no redraws, got %q", out.String())
+	}
+	pb.Finish()
+	line := out.String()
+	if strings.Contains(line, "\r") || strings.Count(line, "\n") != 1 || !strings.HasPrefix(line, "f 100%") {
+		t.Errorf("Value received: %q expected one plain line", line)
+	}
+}
+
+func TestProgressOut(t *testing.T) {
+	var out bytes.Buffer
+	copier := NewSecureCopier()
+	copier.outPipe = &out
+	if copier.progressOut() != &out {
+		t.Errorf("Expected the bars on the output")
+	}
+	copier.IsQuiet = true
+	if copier.progressOut() != ioutil.Discard {
+		t.Errorf("Expected no bars in quiet mode")
+	}
+}
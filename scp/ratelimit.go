@@ -0,0 +1,203 @@
+// Copyright © 2017 Ricardo Aravena <raravena@branch.io>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package scp
+
+import (
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+// bytesPerKbit Converts Kbit/s to bytes per second the way OpenSSH does
+const bytesPerKbit = 1024 / 8
+
+// limitChunk Largest write let through at once, so waits stay short
+const limitChunk = 32 * 1024
+
+// clock Tells the time to the limiters, replaced in tests
+var clock = time.Now
+
+// Limiter Token bucket shared by every transfer it throttles. A limit of 0
+// lets everything through.
+type Limiter struct {
+	mu     sync.Mutex
+	rate   float64
+	tokens float64
+	last   time.Time
+}
+
+// NewLimiter Returns a limiter for kbps Kbit/s
+func NewLimiter(kbps int) *Limiter {
+	l := &Limiter{}
+	l.SetLimit(kbps)
+	return l
+}
+
+// GlobalLimiter Caps the bandwidth of all transfers in this process together
+var GlobalLimiter = NewLimiter(0)
+
+var (
+	hostLimitersMu sync.Mutex
+	hostLimiters   = map[string]*Limiter{}
+)
+
+// HostLimiter Returns the limiter shared by all transfers to or from host
+func HostLimiter(host string) *Limiter {
+	hostLimitersMu.Lock()
+	defer hostLimitersMu.Unlock()
+	host = strings.ToLower(host)
+	l, ok := hostLimiters[host]
+	if !ok {
+		l = NewLimiter(0)
+		hostLimiters[host] = l
+	}
+	return l
+}
+
+// SetLimit Changes the limit, also in the middle of a transfer
+func (l *Limiter) SetLimit(kbps int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.refill(clock())
+	if kbps < 0 {
+		kbps = 0
+	}
+	l.rate = float64(kbps * bytesPerKbit)
+	if l.tokens > l.burst() {
+		l.tokens = l.burst()
+	}
+}
+
+// Limit Returns the limit in Kbit/s, 0 if there is none
+func (l *Limiter) Limit() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return int(l.rate) / bytesPerKbit
+}
+
+// burst Lets a tenth of a second's worth of bytes build up while idle
+func (l *Limiter) burst() float64 {
+	return l.rate / 10
+}
+
+func (l *Limiter) refill(now time.Time) {
+	if !l.last.IsZero() {
+		l.tokens += now.Sub(l.last).Seconds() * l.rate
+		if l.tokens > l.burst() {
+			l.tokens = l.burst()
+		}
+	}
+	l.last = now
+}
+
+// reserve Takes n bytes out of the bucket and returns how long the caller
+// has to wait before sending them. The bucket goes into debt, so whoever
+// comes next waits for this reservation too.
+func (l *Limiter) reserve(n int) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.rate <= 0 {
+		return 0
+	}
+	l.refill(clock())
+	l.tokens -= float64(n)
+	if l.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-l.tokens / l.rate * float64(time.Second))
+}
+
+// Wait Blocks until n bytes can go through
+func (l *Limiter) Wait(n int) {
+	if wait := l.reserve(n); wait > 0 {
+		time.Sleep(wait)
+	}
+}
+
+// limitedWriter Paces writes through every limiter in turn
+type limitedWriter struct {
+	w        io.Writer
+	limiters []*Limiter
+}
+
+func (lw *limitedWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		chunk := p
+		if len(chunk) > limitChunk {
+			chunk = chunk[:limitChunk]
+		}
+		for _, l := range lw.limiters {
+			l.Wait(len(chunk))
+		}
+		n, err := lw.w.Write(chunk)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
+
+// limitedReader Paces reads through every limiter in turn
+type limitedReader struct {
+	r        io.Reader
+	limiters []*Limiter
+}
+
+func (lr *limitedReader) Read(p []byte) (int, error) {
+	if len(p) > limitChunk {
+		p = p[:limitChunk]
+	}
+	n, err := lr.r.Read(p)
+	for _, l := range lr.limiters {
+		l.Wait(n)
+	}
+	return n, err
+}
+
+// limiters Returns the limiters for a transfer with host: the host's own,
+// from the config file unless -l was given on the command line, and the
+// global one
+func (scp *SecureCopier) limiters(host string) []*Limiter {
+	kbps := scp.Limit
+	if hostConfig, ok := scp.Hosts[strings.ToLower(host)]; ok && hostConfig.Limit > 0 && !scp.LimitGiven {
+		kbps = hostConfig.Limit
+	}
+	// kept without a limit too, a reload of the config file may set one
+	l := HostLimiter(host)
+	if kbps > 0 {
+		l.SetLimit(kbps)
+	}
+	return []*Limiter{l, GlobalLimiter}
+}
+
+// limitWriter Throttles file bodies sent to the remote
+func (scp *SecureCopier) limitWriter(w io.Writer) io.Writer {
+	if len(scp.limits) == 0 {
+		return w
+	}
+	return &limitedWriter{w: w, limiters: scp.limits}
+}
+
+// limitReader Throttles file bodies received from the remote
+func (scp *SecureCopier) limitReader(r io.Reader) io.Reader {
+	if len(scp.limits) == 0 {
+		return r
+	}
+	return &limitedReader{r: r, limiters: scp.limits}
+}
@@ -0,0 +1,162 @@
+// Copyright © 2017 Ricardo Aravena <raravena@branch.io>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package scp
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLimiterReserve(t *testing.T) {
+	tests := []struct {
+		name     string
+		kbps     int
+		idle     time.Duration
+		sizes    []int
+		expected time.Duration
+	}{
+		{name: "No limit",
+			kbps:     0,
+			sizes:    []int{1 << 20},
+			expected: 0,
+		},
+		{name: "One second of data",
+			kbps:     8,
+			sizes:    []int{1024},
+			expected: time.Second,
+		},
+		{name: "Debt carries over",
+			kbps:     8,
+			sizes:    []int{1024, 1024},
+			expected: 2 * time.Second,
+		},
+		{name: "Idle time builds up a burst",
+			kbps:     8,
+			idle:     time.Hour,
+			sizes:    []int{1024},
+			expected: 900 * time.Millisecond,
+		},
+	}
+
+	defer func() { clock = time.Now }()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			now := time.Unix(0, 0)
+			clock = func() time.Time { return now }
+			l := NewLimiter(tt.kbps)
+			now = now.Add(tt.idle)
+			var got time.Duration
+			for _, size := range tt.sizes {
+				got = l.reserve(size)
+			}
+			if got != tt.expected {
+				t.Errorf("Value received: %v expected %v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestLimiterSetLimit(t *testing.T) {
+	defer func() { clock = time.Now }()
+	now := time.Unix(0, 0)
+	clock = func() time.Time { return now }
+	l := NewLimiter(8)
+	l.reserve(1024)
+	// the debt is paid off by the time the limit goes up
+	now = now.Add(time.Second)
+	l.SetLimit(16)
+	if l.Limit() != 16 {
+		t.Errorf("Value received: %v expected %v", l.Limit(), 16)
+	}
+	got := l.reserve(2048)
+	if got != time.Second {
+		t.Errorf("Value received: %v expected %v", got, time.Second)
+	}
+	l.SetLimit(0)
+	got = l.reserve(1 << 20)
+	if got != 0 {
+		t.Errorf("Value received: %v expected %v", got, 0)
+	}
+}
+
+func TestLimitedStreams(t *testing.T) {
+	data := strings.Repeat("scpgo", 20000)
+	copier := NewSecureCopier()
+	copier.limits = []*Limiter{NewLimiter(0), NewLimiter(0)}
+
+	var w bytes.Buffer
+	n, err := io.Copy(copier.limitWriter(&w), strings.NewReader(data))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if n != int64(len(data)) || w.String() != data {
+		t.Errorf("Value received: %v expected %v", n, len(data))
+	}
+
+	var r bytes.Buffer
+	n, err = io.Copy(&r, copier.limitReader(strings.NewReader(data)))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if n != int64(len(data)) || r.String() != data {
+		t.Errorf("Value received: %v expected %v", n, len(data))
+	}
+}
+
+func TestLimiters(t *testing.T) {
+	copier := NewSecureCopier()
+	copier.Limit = 100
+	copier.Hosts = map[string]HostConfig{"wan-host": {Limit: 50}, "pinned-host": {Limit: 50}}
+	tests := []struct {
+		name       string
+		host       string
+		limitGiven bool
+		expected   int
+	}{
+		{name: "Host limit from the config file",
+			host:     "WAN-Host",
+			expected: 50,
+		},
+		{name: "Limit from -l",
+			host:     "lan-host",
+			expected: 100,
+		},
+		{name: "-l on the command line wins",
+			host:       "pinned-host",
+			limitGiven: true,
+			expected:   100,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			copier.LimitGiven = tt.limitGiven
+			limiters := copier.limiters(tt.host)
+			if len(limiters) != 2 || limiters[1] != GlobalLimiter {
+				t.Fatalf("Value received: %v expected the host and global limiters", limiters)
+			}
+			if limiters[0] != HostLimiter(tt.host) {
+				t.Errorf("Expected the limiter shared by %s", tt.host)
+			}
+			got := limiters[0].Limit()
+			if got != tt.expected {
+				t.Errorf("Value received: %v expected %v", got, tt.expected)
+			}
+		})
+	}
+}
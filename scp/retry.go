@@ -0,0 +1,109 @@
+// Copyright © 2017 Ricardo Aravena <raravena@branch.io>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package scp
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/raravena80/scpgo/sshconn"
+	"golang.org/x/crypto/ssh"
+)
+
+// DefaultRetryBackoff Wait before the first retry, doubled after each one
+const DefaultRetryBackoff = time.Second
+
+// maxRetryBackoff Caps the wait between retries
+const maxRetryBackoff = time.Minute
+
+// sleep Waits between retries, replaced in tests
+var sleep = time.Sleep
+
+// retryable Tells transient network failures from errors a retry can't fix,
+// like a refused login, a bad host key or the remote scp reporting an error
+func retryable(err error) bool {
+	var exitErr *ssh.ExitError
+	if errors.As(err, &exitErr) {
+		return false
+	}
+	if strings.Contains(err.Error(), "unable to authenticate") {
+		return false
+	}
+	var connectTimeout *sshconn.ConnectTimeoutError
+	var serverAlive *sshconn.ServerAliveError
+	var stall *sshconn.StallError
+	var exitMissing *ssh.ExitMissingError
+	var netErr net.Error
+	switch {
+	case errors.As(err, &connectTimeout), errors.As(err, &serverAlive), errors.As(err, &stall):
+		return true
+	case errors.As(err, &exitMissing), errors.As(err, &netErr):
+		return true
+	case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		return true
+	}
+	// dial errors from the net package
+	var opErr *net.OpError
+	return errors.As(err, &opErr)
+}
+
+// backoff Returns the wait before retry number attempt, counting from 0
+func (scp *SecureCopier) backoff(attempt int) time.Duration {
+	wait := scp.RetryBackoff
+	if wait <= 0 {
+		wait = DefaultRetryBackoff
+	}
+	for i := 0; i < attempt && wait < maxRetryBackoff; i++ {
+		wait *= 2
+	}
+	if wait > maxRetryBackoff {
+		wait = maxRetryBackoff
+	}
+	return wait
+}
+
+// withRetries Runs a transfer, redialing up to Retries times after
+// transient failures
+func (scp *SecureCopier) withRetries(transfer func() error) error {
+	for attempt := 0; ; attempt++ {
+		err := transfer()
+		if err == nil || attempt >= scp.Retries || !retryable(err) {
+			return err
+		}
+		wait := scp.backoff(attempt)
+		scp.retryCount++
+		scp.warn("%v; retry %d of %d in %v", err, attempt+1, scp.Retries, wait)
+		sleep(wait)
+	}
+}
+
+// reportRetries Adds the retries to the summary at the end of the run
+func (scp *SecureCopier) reportRetries() {
+	if scp.retryCount > 0 && !scp.IsQuiet {
+		fmt.Fprintf(scp.errPipe, "%d retry attempt(s), %d file(s) completed\n", scp.retryCount, len(scp.completed))
+	}
+}
+
+// markCompleted Records a file that made it across, so a retry skips it
+func (scp *SecureCopier) markCompleted(path string) {
+	if scp.completed == nil {
+		scp.completed = map[string]bool{}
+	}
+	scp.completed[path] = true
+}
@@ -0,0 +1,217 @@
+// Copyright © 2017 Ricardo Aravena <raravena@branch.io>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package scp
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/raravena80/scpgo/sshconn"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestRetryable(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		expected bool
+	}{
+		{name: "Remote exit status",
+			err:      &ssh.ExitError{},
+			expected: false,
+		},
+		{name: "Refused login",
+			err:      errors.New("ssh: handshake failed: ssh: unable to authenticate"),
+			expected: false,
+		},
+		{name: "Stalled transfer",
+			err:      &sshconn.StallError{Host: "host", Timeout: time.Second},
+			expected: true,
+		},
+		{name: "Dead server",
+			err:      fmt.Errorf("copy: %w", &sshconn.ServerAliveError{Host: "host", Count: 3}),
+			expected: true,
+		},
+		{name: "Dropped connection",
+			err:      io.EOF,
+			expected: true,
+		},
+		{name: "Dial failure",
+			err:      &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")},
+			expected: true,
+		},
+		{name: "Local error",
+			err:      errors.New("no such file or directory"),
+			expected: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := retryable(tt.err)
+			if got != tt.expected {
+				t.Errorf("Value received: %v expected %v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	tests := []struct {
+		name     string
+		backoff  time.Duration
+		attempt  int
+		expected time.Duration
+	}{
+		{name: "Default first wait",
+			attempt:  0,
+			expected: DefaultRetryBackoff,
+		},
+		{name: "Doubles each attempt",
+			backoff:  time.Second,
+			attempt:  3,
+			expected: 8 * time.Second,
+		},
+		{name: "Capped",
+			backoff:  time.Second,
+			attempt:  20,
+			expected: maxRetryBackoff,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			copier := NewSecureCopier()
+			copier.RetryBackoff = tt.backoff
+			got := copier.backoff(tt.attempt)
+			if got != tt.expected {
+				t.Errorf("Value received: %v expected %v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestWithRetries(t *testing.T) {
+	tests := []struct {
+		name     string
+		retries  int
+		failures int
+		err      error
+		attempts int
+		fails    bool
+	}{
+		{name: "Succeeds after transient failures",
+			retries:  3,
+			failures: 2,
+			err:      io.EOF,
+			attempts: 3,
+		},
+		{name: "Gives up after the retries",
+			retries:  2,
+			failures: 5,
+			err:      io.EOF,
+			attempts: 3,
+			fails:    true,
+		},
+		{name: "No retry on a permanent error",
+			retries:  3,
+			failures: 1,
+			err:      &ssh.ExitError{},
+			attempts: 1,
+			fails:    true,
+		},
+		{name: "Retries disabled",
+			retries:  0,
+			failures: 1,
+			err:      io.EOF,
+			attempts: 1,
+			fails:    true,
+		},
+	}
+
+	defer func() { sleep = time.Sleep }()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var waits []time.Duration
+			sleep = func(d time.Duration) { waits = append(waits, d) }
+			copier := NewSecureCopier()
+			copier.errPipe = ioutil.Discard
+			copier.Retries = tt.retries
+			attempts := 0
+			err := copier.withRetries(func() error {
+				attempts++
+				if attempts <= tt.failures {
+					return tt.err
+				}
+				return nil
+			})
+			if (err != nil) != tt.fails {
+				t.Errorf("Value received: %v expected failure %v", err, tt.fails)
+			}
+			if attempts != tt.attempts {
+				t.Errorf("Value received: %v expected %v", attempts, tt.attempts)
+			}
+			if len(waits) != tt.attempts-1 {
+				t.Errorf("Value received: %v expected %v", len(waits), tt.attempts-1)
+			}
+		})
+	}
+}
+
+func TestResponse(t *testing.T) {
+	tests := []struct {
+		name    string
+		acks    string
+		fails   bool
+		skipped bool
+	}{
+		{name: "Accepted",
+			acks: "\x00",
+		},
+		{name: "Record refused",
+			acks:    "\x01scp: a.txt: Permission denied\n",
+			fails:   true,
+			skipped: true,
+		},
+		{name: "Fatal error",
+			acks:  "\x02scp: protocol error\n",
+			fails: true,
+		},
+		{name: "Connection dropped",
+			acks:  "",
+			fails: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			copier := NewSecureCopier()
+			copier.acks = strings.NewReader(tt.acks)
+			err := copier.response()
+			if (err != nil) != tt.fails {
+				t.Errorf("Value received: %v expected failure %v", err, tt.fails)
+			}
+			if skipped(err) != tt.skipped {
+				t.Errorf("Value received: %v expected %v", skipped(err), tt.skipped)
+			}
+		})
+	}
+}
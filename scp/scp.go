@@ -20,8 +20,24 @@ import (
 	"io"
 	"os"
 	"strings"
+	"time"
+
+	"github.com/raravena80/scpgo/pwauth"
+	"github.com/raravena80/scpgo/sshconn"
 )
 
+// HostConfig Settings from the config file that apply to one host
+type HostConfig struct {
+	HostKeyFingerprints []string
+	IdentitiesOnly      bool
+	CryptoPolicy        string
+	Ciphers             []string
+	KexAlgorithms       []string
+	MACs                []string
+	HostKeyAlgorithms   []string
+	Limit               int
+}
+
 // SecureCopier Main data structure
 type SecureCopier struct {
 	Port              int
@@ -31,8 +47,43 @@ type SecureCopier struct {
 	IsQuiet           bool
 	IsVerbose         bool
 	IsCheckKnownHosts bool
+	KnownHostsFiles   []string
+	StrictHostKeys    string
+	HostKeyPins       []string
+	Hosts             map[string]HostConfig
 	Password          bool
-	KeyFile           string
+	AuthMethods       []string
+	PasswordFile      string
+	Batch             bool
+	KeyFiles          []string
+	CertFiles         []string
+	IdentitiesOnly    bool
+	AgentKeys         []string
+	CryptoPolicy      string
+	Ciphers           []string
+	KexAlgorithms     []string
+	MACs              []string
+	HostKeyAlgorithms []string
+	ConnectTimeout    int
+	AliveInterval     int
+	AliveCountMax     int
+	StallTimeout      int
+	Retries           int
+	RetryBackoff      time.Duration
+	Limit             int
+	LimitGiven        bool
+	GlobalLimit       int
+	Parallel          int
+	BufferSize        int
+	Sort              string
+	MaxDepth          int
+	ShowTotal         bool
+	Include           []string
+	Exclude           []string
+	Links             string
+	ReadFifos         bool
+	RetryChanged      int
+	Sparse            bool
 	srcHost           string
 	srcUser           string
 	srcFile           string
@@ -42,6 +93,20 @@ type SecureCopier struct {
 	outPipe           io.Writer
 	errPipe           io.Writer
 	inPipe            io.Reader
+	dirStack          []os.FileInfo
+	changedFiles      []string
+	acks              io.Reader
+	completed         map[string]bool
+	retryCount        int
+	limits            []*Limiter
+	remoteRoot        string
+	plan              *uploadPlan
+	planDirs          []uploadDir
+	worker            bool
+	walkErrors        map[string]bool
+	job               *JobProgress
+	sparseBytes       int64
+	sparseTotal       int64
 }
 
 func NewSecureCopier() SecureCopier {
@@ -65,6 +130,20 @@ func (scp *SecureCopier) Exec(args []string) (int, error) {
 	if scp.IsRemoteTo || scp.IsRemoteFrom {
 		return 1, errors.New("This scp does not implement 'remote-remote scp' yet")
 	}
+	pwauth.SetPrompter(pwauth.NewPrompter(scp.Batch, scp.PasswordFile))
+	err = checkLinksMode(scp.Links)
+	if err != nil {
+		fmt.Fprintln(scp.errPipe, err.Error())
+		return 1, err
+	}
+	err = checkSortMode(scp.Sort)
+	if err != nil {
+		fmt.Fprintln(scp.errPipe, err.Error())
+		return 1, err
+	}
+	if scp.GlobalLimit > 0 {
+		GlobalLimiter.SetLimit(scp.GlobalLimit)
+	}
 
 	scp.srcFile, scp.srcHost, scp.srcUser, err = parseTarget(args[0])
 	if err != nil {
@@ -122,6 +201,53 @@ func (scp *SecureCopier) Exec(args []string) (int, error) {
 	return 0, nil
 }
 
+// connConfig Returns the connection options for a remote end
+func (scp *SecureCopier) connConfig(userName, host string) sshconn.Config {
+	// the config file keys are lower case
+	hostConfig := scp.Hosts[strings.ToLower(host)]
+	// the host's own algorithm settings win over the command line
+	return sshconn.Config{
+		User:                userName,
+		Host:                host,
+		Port:                scp.Port,
+		KeyFiles:            scp.KeyFiles,
+		CertFiles:           scp.CertFiles,
+		Password:            scp.Password || scp.PasswordFile != "" || os.Getenv(pwauth.PasswordEnv) != "",
+		AuthMethods:         scp.AuthMethods,
+		CheckKnownHosts:     scp.IsCheckKnownHosts,
+		KnownHostsFiles:     scp.KnownHostsFiles,
+		StrictHostKeys:      scp.StrictHostKeys,
+		HostKeyPins:         append(append([]string{}, scp.HostKeyPins...), hostConfig.HostKeyFingerprints...),
+		IdentitiesOnly:      scp.IdentitiesOnly || hostConfig.IdentitiesOnly,
+		AgentKeys:           scp.AgentKeys,
+		CryptoPolicy:        orString(hostConfig.CryptoPolicy, scp.CryptoPolicy),
+		Ciphers:             orList(hostConfig.Ciphers, scp.Ciphers),
+		KeyExchanges:        orList(hostConfig.KexAlgorithms, scp.KexAlgorithms),
+		MACs:                orList(hostConfig.MACs, scp.MACs),
+		HostKeyAlgorithms:   orList(hostConfig.HostKeyAlgorithms, scp.HostKeyAlgorithms),
+		ConnectTimeout:      time.Duration(scp.ConnectTimeout) * time.Second,
+		ServerAliveInterval: time.Duration(scp.AliveInterval) * time.Second,
+		ServerAliveCountMax: scp.AliveCountMax,
+		StallTimeout:        time.Duration(scp.StallTimeout) * time.Second,
+		Verbose:             scp.IsVerbose,
+		ErrPipe:             scp.errPipe,
+	}
+}
+
+func orString(first, second string) string {
+	if first != "" {
+		return first
+	}
+	return second
+}
+
+func orList(first, second []string) []string {
+	if len(first) > 0 {
+		return first
+	}
+	return second
+}
+
 //TODO: error for multiple ats or multiple colons
 func parseTarget(target string) (string, string, string, error) {
 	//treat windows drive refs as local
@@ -150,6 +276,13 @@ func parseTarget(target string) (string, string, string, error) {
 	return target, "", "", nil
 }
 
+// warn Prints a warning unless in quiet mode
+func (scp *SecureCopier) warn(format string, args ...interface{}) {
+	if !scp.IsQuiet {
+		fmt.Fprintf(scp.errPipe, "Warning: "+format+"\n", args...)
+	}
+}
+
 func sendByte(w io.Writer, val byte) error {
 	_, err := w.Write([]byte{val})
 	return err
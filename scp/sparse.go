@@ -0,0 +1,125 @@
+// Copyright © 2017 Ricardo Aravena <raravena@branch.io>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package scp
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+// extent A range of a file that holds data
+type extent struct {
+	start int64
+	end   int64
+}
+
+// sparseReader Reads a file, producing holes as zeros without touching the disk
+type sparseReader struct {
+	*os.File
+	extents []extent
+	off     int64
+	size    int64
+	holes   int64
+}
+
+func newSparseReader(f *os.File, size int64) *sparseReader {
+	return &sparseReader{File: f, extents: dataExtents(f, size), size: size}
+}
+
+func (sr *sparseReader) Read(b []byte) (int, error) {
+	if sr.off >= sr.size {
+		return 0, io.EOF
+	}
+	if int64(len(b)) > sr.size-sr.off {
+		b = b[:sr.size-sr.off]
+	}
+	for len(sr.extents) > 0 && sr.extents[0].end <= sr.off {
+		sr.extents = sr.extents[1:]
+	}
+	if len(sr.extents) == 0 || sr.off < sr.extents[0].start {
+		// in a hole: up to the next extent, or the end of the file
+		holeEnd := sr.size
+		if len(sr.extents) > 0 {
+			holeEnd = sr.extents[0].start
+		}
+		if int64(len(b)) > holeEnd-sr.off {
+			b = b[:holeEnd-sr.off]
+		}
+		n, _ := zeroReader{}.Read(b)
+		sr.off += int64(n)
+		sr.holes += int64(n)
+		return n, nil
+	}
+	if int64(len(b)) > sr.extents[0].end-sr.off {
+		b = b[:sr.extents[0].end-sr.off]
+	}
+	n, err := sr.File.ReadAt(b, sr.off)
+	sr.off += int64(n)
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+	return n, err
+}
+
+// isZero Tells whether a block only holds zero bytes
+func isZero(b []byte) bool {
+	for _, c := range b {
+		if c != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// sparseBlock Size of the holes a download can leave
+const sparseBlock = 4096
+
+// writeSparseBlocks Writes b one block at a time, seeking over the blocks
+// that are all zeros
+func writeSparseBlocks(fw *os.File, b []byte) (int64, error) {
+	holes := int64(0)
+	for len(b) > 0 {
+		block := b
+		if len(block) > sparseBlock {
+			block = block[:sparseBlock]
+		}
+		hole, err := writeSparse(fw, block)
+		holes += hole
+		if err != nil {
+			return holes, err
+		}
+		b = b[len(block):]
+	}
+	return holes, nil
+}
+
+// writeSparse Writes b, seeking over it instead when it is all zeros
+func writeSparse(fw *os.File, b []byte) (int64, error) {
+	if isZero(b) {
+		_, err := fw.Seek(int64(len(b)), io.SeekCurrent)
+		return int64(len(b)), err
+	}
+	_, err := fw.Write(b)
+	return 0, err
+}
+
+// reportSparse Prints how much of the transferred data was holes
+func (scp *SecureCopier) reportSparse() {
+	if !scp.Sparse || scp.IsQuiet || scp.sparseTotal == 0 {
+		return
+	}
+	fmt.Fprintf(scp.errPipe, "Sparse: %d of %d bytes were holes\n", scp.sparseBytes, scp.sparseTotal)
+}
@@ -0,0 +1,61 @@
+// Copyright © 2017 Ricardo Aravena <raravena@branch.io>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package scp
+
+import (
+	"io"
+	"os"
+	"syscall"
+)
+
+// lseek whence values for walking data and holes
+const (
+	seekData = 3
+	seekHole = 4
+)
+
+// dataExtents Lists the data ranges of a file with SEEK_DATA/SEEK_HOLE,
+// falling back to the whole file if the filesystem can't tell.
+func dataExtents(f *os.File, size int64) []extent {
+	whole := []extent{{0, size}}
+	var extents []extent
+	off := int64(0)
+	for off < size {
+		start, err := f.Seek(off, seekData)
+		if err != nil {
+			// ENXIO: no data after off, anything else: not supported
+			if len(extents) == 0 && off == 0 && !isNoData(err) {
+				return whole
+			}
+			break
+		}
+		end, err := f.Seek(start, seekHole)
+		if err != nil {
+			return whole
+		}
+		if end > size {
+			end = size
+		}
+		extents = append(extents, extent{start, end})
+		off = end
+	}
+	f.Seek(0, io.SeekStart)
+	return extents
+}
+
+func isNoData(err error) bool {
+	pe, ok := err.(*os.PathError)
+	return ok && pe.Err == syscall.ENXIO
+}
@@ -0,0 +1,123 @@
+// Copyright © 2017 Ricardo Aravena <raravena@branch.io>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package scp
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// makeSparse Creates a file of size bytes with data only at the given offsets
+func makeSparse(t *testing.T, path string, size int64, data map[int64]string) []byte {
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	expected := make([]byte, size)
+	for off, s := range data {
+		f.WriteAt([]byte(s), off)
+		copy(expected[off:], s)
+	}
+	f.Truncate(size)
+	return expected
+}
+
+func TestSendFileSparse(t *testing.T) {
+	tests := []struct {
+		name string
+		size int64
+		data map[int64]string
+	}{
+		{name: "Data in the middle", size: 1 << 20, data: map[int64]string{512 << 10: "middle"}},
+		{name: "Only holes", size: 256 << 10, data: map[int64]string{}},
+		{name: "Dense", size: 6, data: map[int64]string{0: "filled"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "scpgo")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+			path := filepath.Join(dir, "sparse")
+			expected := makeSparse(t, path, tt.size, tt.data)
+			fi, err := os.Stat(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var stream bytes.Buffer
+			copier := NewSecureCopier()
+			copier.outPipe = ioutil.Discard
+			copier.errPipe = ioutil.Discard
+			copier.Sparse = true
+			err = copier.sendFile(&stream, path, fi)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			header := fmt.Sprintf("C%04o %d sparse\n", fi.Mode().Perm(), tt.size)
+			returned := stream.Bytes()
+			if !bytes.Equal(returned, append(append([]byte(header), expected...), 0)) {
+				t.Errorf("Stream does not match the file content")
+			}
+			if copier.sparseTotal != tt.size || copier.sparseBytes > tt.size {
+				t.Errorf("Value received: %v of %v expected at most %v", copier.sparseBytes, copier.sparseTotal, tt.size)
+			}
+		})
+	}
+}
+
+func TestReceiveSparse(t *testing.T) {
+	dir, err := ioutil.TempDir("", "scpgo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	size := 64 << 10
+	body := make([]byte, size)
+	copy(body[8<<10:], "data")
+	copier := NewSecureCopier()
+	copier.outPipe = ioutil.Discard
+	copier.errPipe = ioutil.Discard
+	copier.Sparse = true
+	copier.dstFile = filepath.Join(dir, "out")
+
+	stream, remote := io.Pipe()
+	acks, cw := io.Pipe()
+	go fakeSource(t, []string{fmt.Sprintf("C0644 %d in\n", size), string(body) + "\x00"}, remote, acks)
+	ce := make(chan error, 1)
+	copier.receive(cw, stream, dir, true, ce)
+	select {
+	case err := <-ce:
+		t.Fatalf("Unexpected error: %v", err)
+	default:
+	}
+	returned, err := ioutil.ReadFile(copier.dstFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(returned, body) {
+		t.Errorf("Received file does not match the sent content")
+	}
+	if copier.sparseBytes != int64(size-4096) {
+		t.Errorf("Value received: %v expected %v", copier.sparseBytes, size-4096)
+	}
+}
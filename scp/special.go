@@ -0,0 +1,89 @@
+// Copyright © 2017 Ricardo Aravena <raravena@branch.io>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package scp
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+// specialModes File types whose size can't be trusted for a C record
+const specialModes = os.ModeNamedPipe | os.ModeSocket | os.ModeDevice | os.ModeCharDevice | os.ModeIrregular
+
+func isSpecial(fi os.FileInfo) bool {
+	return fi.Mode()&specialModes != 0
+}
+
+// spooledFileInfo Describes a FIFO read to EOF into a temporary file
+type spooledFileInfo struct {
+	os.FileInfo
+	spool *os.File
+	size  int64
+}
+
+func (si spooledFileInfo) Size() int64 {
+	return si.size
+}
+
+func (si spooledFileInfo) Mode() os.FileMode {
+	return si.FileInfo.Mode() &^ specialModes
+}
+
+// spoolReader Removes the spool file once it has been sent, where it
+// couldn't be removed while open
+type spoolReader struct {
+	*os.File
+}
+
+func (sr spoolReader) Close() error {
+	err := sr.File.Close()
+	os.Remove(sr.File.Name())
+	return err
+}
+
+// handleSpecial Decides what to send for a FIFO, socket or device node.
+// It returns a nil FileInfo when the entry should be left out.
+func (scp *SecureCopier) handleSpecial(path string, fi os.FileInfo) (os.FileInfo, error) {
+	if fi.Mode()&os.ModeNamedPipe == 0 || !scp.ReadFifos {
+		scp.warn("Skipping %s: not a regular file (%v)", path, fi.Mode())
+		return nil, nil
+	}
+	if scp.IsVerbose {
+		fmt.Fprintln(scp.errPipe, "Spooling FIFO "+path)
+	}
+	fifo, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer fifo.Close()
+	spool, err := ioutil.TempFile("", "scpgo-fifo")
+	if err != nil {
+		return nil, err
+	}
+	// the open file stays readable, and nothing is left behind however the
+	// entry ends: sent, refused, retried or given up on
+	defer os.Remove(spool.Name())
+	size, err := io.Copy(spool, fifo)
+	if err == nil {
+		_, err = spool.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		spoolReader{spool}.Close()
+		return nil, err
+	}
+	return spooledFileInfo{fi, spool, size}, nil
+}
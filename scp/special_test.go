@@ -0,0 +1,123 @@
+// Copyright © 2017 Ricardo Aravena <raravena@branch.io>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux || darwin
+// +build linux darwin
+
+package scp
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+func TestProcessDirFifo(t *testing.T) {
+	tests := []struct {
+		name     string
+		readFifo bool
+		contains string
+		warning  string
+	}{
+		{name: "Skip FIFO by default",
+			readFifo: false,
+			warning:  "not a regular file",
+		},
+		{name: "Spool FIFO",
+			readFifo: true,
+			contains: " 3 fifo\nabc\x00",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root, err := ioutil.TempDir("", "scpgo")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(root)
+			fifo := filepath.Join(root, "fifo")
+			err = syscall.Mkfifo(fifo, 0644)
+			if err != nil {
+				t.Skipf("Cannot create FIFO: %v", err)
+			}
+			if tt.readFifo {
+				go func() {
+					w, err := os.OpenFile(fifo, os.O_WRONLY, 0)
+					if err == nil {
+						w.Write([]byte("abc"))
+						w.Close()
+					}
+				}()
+			}
+			copier := NewSecureCopier()
+			copier.ReadFifos = tt.readFifo
+			stream, warnings := uploadTree(t, &copier, root)
+			if tt.contains != "" && !strings.Contains(stream, tt.contains) {
+				t.Errorf("Expected %q in stream %q", tt.contains, stream)
+			}
+			if tt.warning != "" {
+				if !strings.Contains(warnings, tt.warning) {
+					t.Errorf("Expected warning %q in %q", tt.warning, warnings)
+				}
+				if strings.Contains(stream, "fifo") {
+					t.Errorf("Did not expect the FIFO in stream %q", stream)
+				}
+			}
+		})
+	}
+}
+
+func TestHandleSpecialSpool(t *testing.T) {
+	root, err := ioutil.TempDir("", "scpgo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	fifo := filepath.Join(root, "fifo")
+	err = syscall.Mkfifo(fifo, 0644)
+	if err != nil {
+		t.Skipf("Cannot create FIFO: %v", err)
+	}
+	go func() {
+		w, err := os.OpenFile(fifo, os.O_WRONLY, 0)
+		if err == nil {
+			w.Write([]byte("abc"))
+			w.Close()
+		}
+	}()
+	fi, err := os.Lstat(fifo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	copier := NewSecureCopier()
+	copier.ReadFifos = true
+	fi, err = copier.handleSpecial(fifo, fi)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	spool := fi.(spooledFileInfo).spool
+	defer spool.Close()
+	// gone from the disk even if the entry is never sent
+	if _, err := os.Stat(spool.Name()); !os.IsNotExist(err) {
+		t.Errorf("Expected %s to be removed: %v", spool.Name(), err)
+	}
+	b, err := ioutil.ReadAll(spool)
+	if err != nil || string(b) != "abc" {
+		t.Errorf("Value received: %q (%v) expected %q", b, err, "abc")
+	}
+}
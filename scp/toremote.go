@@ -18,67 +18,253 @@ import (
 	"errors"
 	"fmt"
 	"github.com/raravena80/scpgo/sshconn"
+	"golang.org/x/crypto/ssh"
 	"io"
+	"io/ioutil"
 	"os"
+	"path"
 	"path/filepath"
+	"strings"
 )
 
 func (scp *SecureCopier) processDir(procWriter io.Writer, srcFilePath string, srcFileInfo os.FileInfo) error {
 
-	err := scp.sendDir(procWriter, srcFilePath, srcFileInfo)
-	if err != nil {
-		return err
-	}
+	// opened first, an unreadable directory is left out rather than sent empty
 	dir, err := os.Open(srcFilePath)
 	if err != nil {
-		return err
+		scp.walkFailed(srcFilePath, err)
+		return nil
+	}
+	defer dir.Close()
+	err = scp.sendDir(procWriter, srcFilePath, srcFileInfo)
+	if skipped(err) {
+		// the remote refused the directory, leave it out
+		fmt.Fprintln(scp.errPipe, err.Error())
+		return nil
 	}
-	fis, err := dir.Readdir(0)
 	if err != nil {
 		return err
 	}
-	for _, fi := range fis {
-		if fi.IsDir() {
-			err = scp.processDir(procWriter, filepath.Join(srcFilePath, fi.Name()), fi)
-			if err != nil {
-				return err
-			}
-		} else {
-			err = scp.sendFile(procWriter, filepath.Join(srcFilePath, fi.Name()), fi)
-			if err != nil {
-				return err
-			}
+	// remember the directories being walked, following symlinks may loop
+	scp.dirStack = append(scp.dirStack, srcFileInfo)
+	defer func() { scp.dirStack = scp.dirStack[:len(scp.dirStack)-1] }()
+	if scp.plan != nil {
+		scp.planDirs = append(scp.planDirs, uploadDir{name: filepath.Base(srcFilePath), mode: srcFileInfo.Mode()})
+		scp.plan.addDir(scp.remoteRoot, scp.planDirs)
+		defer func() { scp.planDirs = scp.planDirs[:len(scp.planDirs)-1] }()
+	}
+	if scp.belowMaxDepth() {
+		err = scp.readDir(dir, srcFilePath, func(fi os.FileInfo) error {
+			return scp.processEntry(procWriter, srcFilePath, fi)
+		})
+		if err != nil {
+			return err
 		}
 	}
 	err = scp.sendEndDir(procWriter)
 	return err
 }
 
+// processEntry Sends one entry of a directory being walked
+func (scp *SecureCopier) processEntry(procWriter io.Writer, dirPath string, fi os.FileInfo) error {
+	path := filepath.Join(dirPath, fi.Name())
+	rel, relErr := filepath.Rel(scp.srcFile, path)
+	// an excluded link is left out quietly, whatever it points to
+	if relErr == nil && scp.isFiltered(rel, true) {
+		if scp.IsVerbose {
+			fmt.Fprintln(scp.errPipe, "Skipping filtered: "+rel)
+		}
+		return nil
+	}
+	var err error
+	if fi.Mode()&os.ModeSymlink != 0 {
+		fi, err = scp.resolveLink(path, fi)
+		if err != nil {
+			scp.walkFailed(path, err)
+			return nil
+		}
+		if fi == nil {
+			return nil
+		}
+	}
+	// includes only restrict files, so a link is matched as what it points to
+	if relErr == nil && scp.isFiltered(rel, fi.IsDir()) {
+		if scp.IsVerbose {
+			fmt.Fprintln(scp.errPipe, "Skipping filtered: "+rel)
+		}
+		return nil
+	}
+	if isSpecial(fi) {
+		fi, err = scp.handleSpecial(path, fi)
+		if err != nil {
+			scp.walkFailed(path, err)
+			return nil
+		}
+		if fi == nil {
+			return nil
+		}
+	}
+	if fi.IsDir() {
+		return scp.processDir(procWriter, path, fi)
+	}
+	return scp.sendFile(procWriter, path, fi)
+}
+
 func (scp *SecureCopier) sendEndDir(procWriter io.Writer) error {
 	header := fmt.Sprintf("E\n")
 	if scp.IsVerbose {
 		fmt.Fprintf(scp.errPipe, "Sending end dir: %s", header)
 	}
 	_, err := procWriter.Write([]byte(header))
+	if err == nil {
+		err = scp.response()
+	}
+	if skipped(err) {
+		fmt.Fprintln(scp.errPipe, err.Error())
+		return nil
+	}
 	return err
 }
 
 func (scp *SecureCopier) sendDir(procWriter io.Writer, srcPath string, srcFileInfo os.FileInfo) error {
-	mode := uint32(srcFileInfo.Mode().Perm())
-	header := fmt.Sprintf("D%04o 0 %s\n", mode, filepath.Base(srcPath))
+	name := filepath.Base(srcPath)
+	if srcPath == scp.srcFile && scp.remoteRoot != "" {
+		// the remote scp runs in the root's parent
+		name = path.Base(scp.remoteRoot)
+	}
+	return scp.sendDirRecord(procWriter, name, srcFileInfo.Mode())
+}
+
+// sendDirRecord Sends a D record, entering the directory name on the remote
+func (scp *SecureCopier) sendDirRecord(procWriter io.Writer, name string, fileMode os.FileMode) error {
+	mode := uint32(fileMode.Perm())
+	if scp.plan != nil {
+		// the sessions of a parallel upload write into it after this one is
+		// done and the remote has set the final mode
+		mode |= 0700
+	}
+	header := fmt.Sprintf("D%04o 0 %s\n", mode, name)
 	if scp.IsVerbose {
 		fmt.Fprintf(scp.errPipe, "Sending Dir header : %s", header)
 	}
 	_, err := procWriter.Write([]byte(header))
-	return err
+	if err != nil {
+		return err
+	}
+	return scp.response()
+}
+
+// remoteError An error the remote scp sent instead of an ack
+type remoteError struct {
+	msg   string
+	fatal bool
+}
+
+func (e *remoteError) Error() string {
+	return e.msg
+}
+
+// skipped Reports whether the remote refused a record but carries on
+func skipped(err error) bool {
+	re, ok := err.(*remoteError)
+	return ok && !re.fatal
+}
+
+// response Reads the remote's ack of the last record. Without an ack stream,
+// as when writing to a buffer, every record counts as accepted.
+func (scp *SecureCopier) response() error {
+	if scp.acks == nil {
+		return nil
+	}
+	b := make([]byte, 1)
+	_, err := io.ReadFull(scp.acks, b)
+	if err != nil {
+		return err
+	}
+	if b[0] == 0 {
+		return nil
+	}
+	// 1 is an error for this record, 2 ends the transfer
+	code := b[0]
+	var msg []byte
+	for {
+		_, err = io.ReadFull(scp.acks, b)
+		if err != nil || b[0] == '\n' {
+			break
+		}
+		msg = append(msg, b[0])
+	}
+	if err != nil {
+		return err
+	}
+	return &remoteError{msg: string(msg), fatal: code != 1}
+}
+
+// openSource Opens the content to send for a file of the upload
+func (scp *SecureCopier) openSource(path string, fi os.FileInfo) (io.ReadCloser, error) {
+	switch info := fi.(type) {
+	case linkFileInfo:
+		return ioutil.NopCloser(strings.NewReader(info.target)), nil
+	case spooledFileInfo:
+		return spoolReader{info.spool}, nil
+	}
+	f, err := os.Open(path)
+	if err != nil || !scp.Sparse {
+		return f, err
+	}
+	return newSparseReader(f, fi.Size()), nil
+}
+
+// zeroReader Reads an endless run of zero bytes
+type zeroReader struct{}
+
+func (zeroReader) Read(b []byte) (int, error) {
+	for i := range b {
+		b[i] = 0
+	}
+	return len(b), nil
 }
 
 func (scp *SecureCopier) sendFile(procWriter io.Writer, srcPath string, srcFileInfo os.FileInfo) error {
+	if scp.completed[srcPath] {
+		if scp.IsVerbose {
+			fmt.Fprintln(scp.errPipe, "Skipping completed: "+srcPath)
+		}
+		return nil
+	}
+	if scp.plan != nil {
+		// left to the sessions of a parallel upload
+		scp.plan.add(srcPath, srcFileInfo, scp.planDirs)
+		return nil
+	}
+	for attempt := 0; ; attempt++ {
+		changed, err := scp.sendFileOnce(procWriter, srcPath, srcFileInfo)
+		if err != nil || !changed {
+			return err
+		}
+		if attempt >= scp.RetryChanged {
+			scp.changedFiles = append(scp.changedFiles, srcPath)
+			return nil
+		}
+		scp.warn("Resending %s", srcPath)
+		srcFileInfo, err = os.Stat(srcPath)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// sendFileOnce Sends one C record. The body is always exactly the announced
+// size: extra bytes are left out and missing ones are padded with zeros.
+// It reports whether the file changed size while being sent.
+func (scp *SecureCopier) sendFileOnce(procWriter io.Writer, srcPath string, srcFileInfo os.FileInfo) (bool, error) {
 	// single file
 	mode := uint32(srcFileInfo.Mode().Perm())
-	fileReader, err := os.Open(srcPath)
+	fileReader, err := scp.openSource(srcPath, srcFileInfo)
 	if err != nil {
-		return err
+		// nothing is sent yet, so the others can still go
+		scp.walkFailed(srcPath, err)
+		return false, nil
 	}
 	defer fileReader.Close()
 	size := srcFileInfo.Size()
@@ -86,34 +272,92 @@ func (scp *SecureCopier) sendFile(procWriter io.Writer, srcPath string, srcFileI
 	if scp.IsVerbose {
 		fmt.Fprintf(scp.errPipe, "Sending File header: %s", header)
 	}
-	pb := NewProgressBarTo(srcPath, size, scp.outPipe)
+	pb := NewProgressBarTo(srcPath, size, scp.progressOut())
+	pb.Job = scp.job
 	pb.Update(0)
 	_, err = procWriter.Write([]byte(header))
+	if err == nil {
+		err = scp.response()
+	}
+	if skipped(err) {
+		fmt.Fprintln(scp.errPipe, err.Error())
+		return false, nil
+	}
 	if err != nil {
-		return err
+		return false, err
 	}
-	// TODO buffering
-	_, err = io.Copy(procWriter, fileReader)
+	body := scp.limitWriter(procWriter)
+	n, err := scp.copyBody(&progressWriter{w: body, pb: &pb}, fileReader, size)
 	if err != nil {
-		return err
+		return false, err
+	}
+	changed := false
+	if n < size {
+		// the file shrank, keep the stream in sync like OpenSSH does
+		_, err = io.CopyN(body, zeroReader{}, size-n)
+		if err != nil {
+			return false, err
+		}
+		changed = true
+	} else if f, ok := fileReader.(interface {
+		Stat() (os.FileInfo, error)
+	}); ok {
+		fi, err := f.Stat()
+		changed = err == nil && fi.Size() != size
+	}
+	if sr, ok := fileReader.(*sparseReader); ok {
+		scp.sparseBytes += sr.holes
+		scp.sparseTotal += size
+		if scp.IsVerbose {
+			fmt.Fprintf(scp.errPipe, "%s: %d of %d bytes sparse\n", srcPath, sr.holes, size)
+		}
+	}
+	if changed {
+		scp.warn("%s: file changed size while being sent", srcPath)
+	}
+	if n < size {
+		// report the padded file instead of terminating with a null byte
+		_, err = fmt.Fprintf(procWriter, "\x01scp: %s: file shrank while being sent\n", srcPath)
+	} else {
+		// terminate with null byte
+		err = sendByte(procWriter, 0)
+	}
+	if err == nil {
+		err = scp.response()
+	}
+	if skipped(err) {
+		fmt.Fprintln(scp.errPipe, err.Error())
+		return changed, nil
 	}
-	// terminate with null byte
-	err = sendByte(procWriter, 0)
 	if err != nil {
-		return err
+		return changed, err
+	}
+	if scp.acks != nil {
+		scp.markCompleted(srcPath)
 	}
 
 	err = fileReader.Close()
 	if scp.IsVerbose {
 		fmt.Fprintln(scp.errPipe, "Sent file plus null-byte.")
 	}
-	pb.Update(size)
-	fmt.Fprintln(scp.errPipe)
+	pb.Finish()
 
 	if err != nil {
 		fmt.Fprintln(scp.errPipe, err.Error())
 	}
-	return err
+	return changed, err
+}
+
+// reportChanged Lists the files that changed size while being sent
+func (scp *SecureCopier) reportChanged() error {
+	if len(scp.changedFiles) == 0 {
+		return nil
+	}
+	scp.warn("%d file(s) changed while being sent:", len(scp.changedFiles))
+	for _, path := range scp.changedFiles {
+		fmt.Fprintln(scp.errPipe, "  "+path)
+	}
+	return fmt.Errorf("%d file(s) changed while being sent", len(scp.changedFiles))
 }
 
 // to scp
@@ -124,55 +368,151 @@ func (scp *SecureCopier) scpToRemote() error {
 		fmt.Fprintln(scp.errPipe, "Could not stat source file "+scp.srcFile)
 		return err
 	}
-	session, err := sshconn.Connect(scp.dstUser, scp.dstHost, scp.Port, scp.KeyFile, scp.Password, scp.IsCheckKnownHosts, scp.IsVerbose, scp.errPipe)
-	if err != nil {
-		return err
-	} else if scp.IsVerbose {
-		fmt.Fprintln(scp.errPipe, "Got session")
+	if isSpecial(srcFileInfo) {
+		srcFileInfo, err = scp.handleSpecial(scp.srcFile, srcFileInfo)
+		if err != nil {
+			return err
+		}
+		if srcFileInfo == nil {
+			return errors.New(scp.srcFile + ": not a regular file")
+		}
+	}
+	if !scp.IsRecursive && srcFileInfo.IsDir() {
+		return errors.New("Error: Not a regular file")
 	}
-	defer session.Close()
-	ce := make(chan error)
 	if scp.dstFile == "" {
 		scp.dstFile = filepath.Base(scp.srcFile)
 	}
-	go func() {
-		procWriter, err := session.StdinPipe()
-		if err != nil {
-			fmt.Fprintln(scp.errPipe, err.Error())
-			ce <- err
-			return
+	if scp.ShowTotal && srcFileInfo.IsDir() && scp.Parallel <= 1 {
+		// a parallel upload's bar already covers the whole job
+		files, size := scp.scanTotals(scp.srcFile, srcFileInfo)
+		if scp.IsVerbose {
+			fmt.Fprintf(scp.errPipe, "Found %d file(s), %d bytes to send\n", files, size)
 		}
-		defer procWriter.Close()
-		if scp.IsRecursive {
-			if srcFileInfo.IsDir() {
-				err = scp.processDir(procWriter, scp.srcFile, srcFileInfo)
-			} else {
-				err = scp.sendFile(procWriter, scp.srcFile, srcFileInfo)
-			}
-		} else {
-			if srcFileInfo.IsDir() {
-				ce <- errors.New("Error: Not a regular file")
-				return
+		scp.job = NewJobProgress(files, size)
+	}
+	scp.limits = scp.limiters(scp.dstHost)
+	err = scp.withRetries(func() error {
+		return scp.uploadOnce(srcFileInfo)
+	})
+	scp.reportSparse()
+	scp.reportRetries()
+	changedErr := scp.reportChanged()
+	if err == nil {
+		err = changedErr
+	}
+	if walkErr := scp.reportWalkErrors(); err == nil {
+		err = walkErr
+	}
+	return err
+}
+
+// uploadOnce Connects and sends whatever hasn't been acked by an earlier
+// attempt. The scp protocol has no offsets, so a file cut off halfway is
+// sent again from the start.
+func (scp *SecureCopier) uploadOnce(srcFileInfo os.FileInfo) error {
+	monitor := sshconn.NewMonitor()
+	cfg := scp.connConfig(scp.dstUser, scp.dstHost)
+	cfg.Monitor = monitor
+	client, err := sshconn.Dial(cfg)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	defer monitor.Stop()
+	target := scp.dstFile
+	if srcFileInfo.IsDir() && (scp.Retries > 0 || scp.Parallel > 1) {
+		// a single attempt leaves it to the remote scp, like scp -r
+		err = scp.resolveRemoteRoot(client)
+		target = path.Dir(scp.remoteRoot)
+	}
+	if err == nil && srcFileInfo.IsDir() && scp.Parallel > 1 {
+		err = scp.uploadParallel(client, monitor, srcFileInfo)
+	} else if err == nil {
+		var session *ssh.Session
+		session, err = client.NewSession()
+		if err == nil {
+			if scp.IsVerbose {
+				fmt.Fprintln(scp.errPipe, "Got session")
 			}
-			err = scp.sendFile(procWriter, scp.srcFile, srcFileInfo)
+			err = scp.runSink(session, monitor, target, func(procWriter io.Writer) error {
+				if srcFileInfo.IsDir() {
+					return scp.processDir(procWriter, scp.srcFile, srcFileInfo)
+				}
+				return scp.sendFile(procWriter, scp.srcFile, srcFileInfo)
+			})
+		}
+	}
+	// a write fails first when the monitor drops the connection
+	if mErr := monitor.Err(); mErr != nil {
+		err = mErr
+	}
+	if err != nil {
+		fmt.Fprintln(scp.errPipe, "Failed to run remote scp: "+err.Error())
+	}
+	return err
+}
+
+// resolveRemoteRoot Works out the directory a recursive upload fills, the way
+// the remote scp does: the source's name inside dstFile when that is a
+// directory already, dstFile itself otherwise. Retries and parallel uploads
+// need it, and it is only done once, so a retry doesn't nest the tree inside
+// the one the first attempt created.
+func (scp *SecureCopier) resolveRemoteRoot(client *ssh.Client) error {
+	if scp.remoteRoot != "" {
+		return nil
+	}
+	session, err := client.NewSession()
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+	err = session.Run("test -d " + shellQuote(scp.dstFile))
+	var exitErr *ssh.ExitError
+	if errors.As(err, &exitErr) {
+		scp.remoteRoot = scp.dstFile
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	scp.remoteRoot = path.Join(scp.dstFile, filepath.Base(scp.srcFile))
+	return nil
+}
 
+// runSink Runs the remote scp in to mode on session and feeds it with send
+// once it is ready
+func (scp *SecureCopier) runSink(session *ssh.Session, monitor *sshconn.Monitor, target string, send func(procWriter io.Writer) error) error {
+	defer session.Close()
+	stdin, err := session.StdinPipe()
+	if err != nil {
+		fmt.Fprintln(scp.errPipe, err.Error())
+		return err
+	}
+	stdout, err := session.StdoutPipe()
+	if err != nil {
+		fmt.Fprintln(scp.errPipe, err.Error())
+		return err
+	}
+	scp.acks = monitor.Reader(stdout)
+	defer func() { scp.acks = nil }()
+	ce := make(chan error, 1)
+	go func() {
+		procWriter := monitor.Writer(stdin)
+		defer procWriter.Close()
+		// the remote scp acks once it is ready
+		err := scp.response()
+		if err == nil {
+			err = send(procWriter)
 		}
-		if err != nil {
-			fmt.Fprintln(scp.errPipe, err.Error())
-			ce <- err
+		if err == nil {
+			err = procWriter.Close()
 		}
-		err = procWriter.Close()
 		if err != nil {
 			fmt.Fprintln(scp.errPipe, err.Error())
 			ce <- err
-			return
-		}
-	}()
-	go func() {
-		select {
-		case err, ok := <-ce:
-			fmt.Fprintln(scp.errPipe, "Error:", err, ok)
-			os.Exit(1)
+			// make Run give up on the remote too
+			session.Close()
 		}
 	}()
 
@@ -183,9 +523,11 @@ func (scp *SecureCopier) scpToRemote() error {
 	if scp.IsRecursive {
 		remoteOpts += "r"
 	}
-	err = session.Run("/usr/bin/scp " + remoteOpts + " " + scp.dstFile)
-	if err != nil {
-		fmt.Fprintln(scp.errPipe, "Failed to run remote scp: "+err.Error())
+	err = session.Run("/usr/bin/scp " + remoteOpts + " " + shellQuote(target))
+	select {
+	case sendErr := <-ce:
+		err = sendErr
+	default:
 	}
 	return err
 }
@@ -0,0 +1,272 @@
+// Copyright © 2017 Ricardo Aravena <raravena@branch.io>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package scp
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// uploadTree Runs processDir over root and returns the protocol stream and warnings
+func uploadTree(t *testing.T, copier *SecureCopier, root string) (string, string) {
+	var stream, warnings bytes.Buffer
+	copier.outPipe = ioutil.Discard
+	copier.errPipe = &warnings
+	copier.srcFile = root
+	fi, err := os.Stat(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = copier.processDir(&stream, root, fi)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	return stream.String(), warnings.String()
+}
+
+func TestProcessDirLinks(t *testing.T) {
+	root, err := ioutil.TempDir("", "scpgo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	ioutil.WriteFile(filepath.Join(root, "a.txt"), []byte("hello"), 0644)
+	os.Symlink("a.txt", filepath.Join(root, "link"))
+	os.Symlink(".", filepath.Join(root, "loop"))
+	os.Symlink("missing", filepath.Join(root, "broken"))
+
+	tests := []struct {
+		name     string
+		links    string
+		contains []string
+		missing  []string
+		warnings []string
+	}{
+		{name: "Follow",
+			links:    LinksFollow,
+			contains: []string{" 5 a.txt\nhello\x00", " 5 link\nhello\x00"},
+			missing:  []string{"D0755 0 loop\n", " broken\n"},
+			warnings: []string{"loops back", "broken symlink"},
+		},
+		{name: "Skip",
+			links:    LinksSkip,
+			contains: []string{" 5 a.txt\nhello\x00"},
+			missing:  []string{" link\n", " loop\n", " broken\n"},
+			warnings: []string{"Skipping symlink " + filepath.Join(root, "link")},
+		},
+		{name: "Copy as file",
+			links:    LinksCopyAsFile,
+			contains: []string{" 5 link\na.txt\x00", " 1 loop\n.\x00", " 7 broken\nmissing\x00"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			copier := NewSecureCopier()
+			copier.Links = tt.links
+			stream, warnings := uploadTree(t, &copier, root)
+			for _, c := range tt.contains {
+				if !strings.Contains(stream, c) {
+					t.Errorf("Expected %q in stream %q", c, stream)
+				}
+			}
+			for _, m := range tt.missing {
+				if strings.Contains(stream, m) {
+					t.Errorf("Did not expect %q in stream %q", m, stream)
+				}
+			}
+			for _, w := range tt.warnings {
+				if !strings.Contains(warnings, w) {
+					t.Errorf("Expected warning %q in %q", w, warnings)
+				}
+			}
+		})
+	}
+}
+
+func TestProcessDirLinksFiltered(t *testing.T) {
+	root, err := ioutil.TempDir("", "scpgo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	target := filepath.Join(root, "target")
+	os.Mkdir(target, 0755)
+	ioutil.WriteFile(filepath.Join(target, "keep.txt"), []byte("keep"), 0644)
+	ioutil.WriteFile(filepath.Join(target, "drop.log"), []byte("drop"), 0644)
+	os.Symlink("target", filepath.Join(root, "linked"))
+
+	tests := []struct {
+		name     string
+		links    string
+		include  []string
+		exclude  []string
+		contains []string
+		missing  []string
+		files    int
+	}{
+		{name: "Followed link matched as a directory",
+			links:    LinksFollow,
+			include:  []string{"*.txt"},
+			contains: []string{"D0755 0 linked\n", " 4 keep.txt\n"},
+			missing:  []string{"drop.log"},
+			files:    2,
+		},
+		{name: "Link copied as a file matched as a file",
+			links:   LinksCopyAsFile,
+			include: []string{"*.txt"},
+			missing: []string{" linked\n"},
+			files:   1,
+		},
+		{name: "Excluded link",
+			links:    LinksFollow,
+			exclude:  []string{"linked"},
+			contains: []string{"D0755 0 target\n"},
+			missing:  []string{" linked\n"},
+			files:    2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			copier := NewSecureCopier()
+			copier.Links = tt.links
+			copier.Include = tt.include
+			copier.Exclude = tt.exclude
+			stream, warnings := uploadTree(t, &copier, root)
+			for _, c := range tt.contains {
+				if !strings.Contains(stream, c) {
+					t.Errorf("Expected %q in stream %q", c, stream)
+				}
+			}
+			for _, m := range tt.missing {
+				if strings.Contains(stream, m) {
+					t.Errorf("Did not expect %q in stream %q", m, stream)
+				}
+			}
+			if warnings != "" {
+				t.Errorf("Did not expect warnings %q", warnings)
+			}
+			fi, err := os.Stat(root)
+			if err != nil {
+				t.Fatal(err)
+			}
+			files, _ := copier.scanTotals(root, fi)
+			if files != tt.files {
+				t.Errorf("Value received: %v expected %v", files, tt.files)
+			}
+		})
+	}
+}
+
+func TestCheckLinksMode(t *testing.T) {
+	tests := []struct {
+		name     string
+		mode     string
+		expected bool
+	}{
+		{name: "Default", mode: "", expected: true},
+		{name: "Follow", mode: LinksFollow, expected: true},
+		{name: "Preserve needs SFTP", mode: LinksPreserve, expected: false},
+		{name: "Unknown", mode: "bogus", expected: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			returned := checkLinksMode(tt.mode) == nil
+			if returned != tt.expected {
+				t.Errorf("Value received: %v expected %v", returned, tt.expected)
+			}
+		})
+	}
+}
+
+// staleInfo Pretends a file had another size when it was stat'ed
+type staleInfo struct {
+	os.FileInfo
+	size int64
+}
+
+func (si staleInfo) Size() int64 {
+	return si.size
+}
+
+func TestSendFileChangedSize(t *testing.T) {
+	tests := []struct {
+		name     string
+		stale    int64
+		retries  int
+		expected string
+		changed  int
+	}{
+		{name: "Grown file is capped",
+			stale:    3,
+			expected: "C0644 3 f.txt\nhel\x00",
+			changed:  1,
+		},
+		{name: "Shrunk file is padded",
+			stale:    7,
+			expected: "C0644 7 f.txt\nhello\x00\x00\x01scp: ",
+			changed:  1,
+		},
+		{name: "Changed file is resent",
+			stale:    3,
+			retries:  1,
+			expected: "C0644 3 f.txt\nhel\x00C0644 5 f.txt\nhello\x00",
+			changed:  0,
+		},
+		{name: "Unchanged file",
+			stale:    5,
+			expected: "C0644 5 f.txt\nhello\x00",
+			changed:  0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "scpgo")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+			path := filepath.Join(dir, "f.txt")
+			ioutil.WriteFile(path, []byte("hello"), 0644)
+			os.Chmod(path, 0644)
+			fi, err := os.Stat(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var stream bytes.Buffer
+			copier := NewSecureCopier()
+			copier.outPipe = ioutil.Discard
+			copier.errPipe = ioutil.Discard
+			copier.RetryChanged = tt.retries
+			err = copier.sendFile(&stream, path, staleInfo{fi, tt.stale})
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if !strings.HasPrefix(stream.String(), tt.expected) {
+				t.Errorf("Value received: %q expected %q", stream.String(), tt.expected)
+			}
+			if len(copier.changedFiles) != tt.changed {
+				t.Errorf("Value received: %v expected %v", len(copier.changedFiles), tt.changed)
+			}
+		})
+	}
+}
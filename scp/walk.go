@@ -0,0 +1,146 @@
+// Copyright © 2017 Ricardo Aravena <raravena@branch.io>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package scp
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+const (
+	// SortNone Sends entries in the order the OS lists them (the default)
+	SortNone = "none"
+	// SortName Sends the entries of each directory by name
+	SortName = "name"
+	// SortSize Sends the entries of each directory smallest first
+	SortSize = "size"
+	// SortMtime Sends the entries of each directory oldest first
+	SortMtime = "mtime"
+)
+
+// walkBatch Entries read from a directory at a time when not sorting
+const walkBatch = 1024
+
+// checkSortMode Validates the --sort option
+func checkSortMode(mode string) error {
+	switch mode {
+	case "", SortNone, SortName, SortSize, SortMtime:
+		return nil
+	}
+	return fmt.Errorf("Unknown --sort order '%s' (use %s, %s, %s or %s)", mode, SortNone, SortName, SortSize, SortMtime)
+}
+
+// readDir Calls visit for each entry of dir, in batches as the OS lists
+// them. Sorting needs the whole directory: only the names for --sort=name,
+// everything Lstat returns for size and mtime. An error from visit stops
+// the walk, one reading the directory is reported and leaves the rest of
+// it out.
+func (scp *SecureCopier) readDir(dir *os.File, dirPath string, visit func(fi os.FileInfo) error) error {
+	switch scp.Sort {
+	case SortName:
+		names, err := dir.Readdirnames(-1)
+		if err != nil {
+			scp.walkFailed(dirPath, err)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			path := filepath.Join(dirPath, name)
+			fi, err := os.Lstat(path)
+			if err != nil {
+				scp.walkFailed(path, err)
+				continue
+			}
+			err = visit(fi)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	case SortSize, SortMtime:
+		fis, err := dir.Readdir(-1)
+		if err != nil {
+			scp.walkFailed(dirPath, err)
+		}
+		sortEntries(fis, scp.Sort)
+		for _, fi := range fis {
+			err = visit(fi)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	for {
+		fis, err := dir.Readdir(walkBatch)
+		for _, fi := range fis {
+			vErr := visit(fi)
+			if vErr != nil {
+				return vErr
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			scp.walkFailed(dirPath, err)
+			return nil
+		}
+	}
+}
+
+// sortEntries Sorts by size or mtime, ties by name
+func sortEntries(fis []os.FileInfo, mode string) {
+	sort.Slice(fis, func(i, j int) bool {
+		a, b := fis[i], fis[j]
+		switch {
+		case mode == SortSize && a.Size() != b.Size():
+			return a.Size() < b.Size()
+		case mode == SortMtime && !a.ModTime().Equal(b.ModTime()):
+			return a.ModTime().Before(b.ModTime())
+		}
+		return a.Name() < b.Name()
+	})
+}
+
+// belowMaxDepth Tells whether the entries of the directory being walked are
+// still within --max-depth, counting the source's own entries as depth 1
+func (scp *SecureCopier) belowMaxDepth() bool {
+	return scp.MaxDepth <= 0 || len(scp.dirStack) <= scp.MaxDepth
+}
+
+// walkFailed Reports a file or directory that couldn't be read. It is left
+// out and the upload goes on, failing at the end like OpenSSH scp does.
+func (scp *SecureCopier) walkFailed(path string, err error) {
+	if _, ok := err.(*os.PathError); ok {
+		fmt.Fprintln(scp.errPipe, "Walk error: "+err.Error())
+	} else {
+		fmt.Fprintln(scp.errPipe, "Walk error: "+path+": "+err.Error())
+	}
+	if scp.walkErrors == nil {
+		scp.walkErrors = map[string]bool{}
+	}
+	scp.walkErrors[path] = true
+}
+
+// reportWalkErrors Fails the upload when anything was left out unread
+func (scp *SecureCopier) reportWalkErrors() error {
+	if len(scp.walkErrors) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%d file(s) or directories could not be read", len(scp.walkErrors))
+}
@@ -0,0 +1,214 @@
+// Copyright © 2017 Ricardo Aravena <raravena@branch.io>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package scp
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCheckSortMode(t *testing.T) {
+	tests := []struct {
+		name     string
+		mode     string
+		expected bool
+	}{
+		{name: "Default", mode: "", expected: true},
+		{name: "None", mode: SortNone, expected: true},
+		{name: "Mtime", mode: SortMtime, expected: true},
+		{name: "Unknown", mode: "random", expected: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			returned := checkSortMode(tt.mode) == nil
+			if returned != tt.expected {
+				t.Errorf("Value received: %v expected %v", returned, tt.expected)
+			}
+		})
+	}
+}
+
+func TestProcessDirSort(t *testing.T) {
+	root, err := ioutil.TempDir("", "scpgo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	os.Chmod(root, 0755)
+	files := []struct {
+		name string
+		size int
+		age  time.Duration
+	}{
+		{name: "b", size: 1, age: time.Hour},
+		{name: "c", size: 3, age: 3 * time.Hour},
+		{name: "a", size: 2, age: 2 * time.Hour},
+	}
+	now := time.Now()
+	for _, f := range files {
+		path := filepath.Join(root, f.name)
+		ioutil.WriteFile(path, []byte(strings.Repeat("x", f.size)), 0644)
+		os.Chtimes(path, now.Add(-f.age), now.Add(-f.age))
+	}
+
+	tests := []struct {
+		name     string
+		sort     string
+		expected []string
+	}{
+		{name: "By name",
+			sort:     SortName,
+			expected: []string{"a", "b", "c"},
+		},
+		{name: "By size",
+			sort:     SortSize,
+			expected: []string{"b", "a", "c"},
+		},
+		{name: "By mtime",
+			sort:     SortMtime,
+			expected: []string{"c", "a", "b"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			copier := NewSecureCopier()
+			copier.Sort = tt.sort
+			stream, _ := uploadTree(t, &copier, root)
+			got := []string{}
+			for _, rec := range records(stream) {
+				if strings.HasPrefix(rec, "C") {
+					got = append(got, rec[strings.LastIndex(rec, " ")+1:])
+				}
+			}
+			if !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("Value received: %v expected %v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestProcessDirMaxDepth(t *testing.T) {
+	root, err := ioutil.TempDir("", "scpgo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	os.Chmod(root, 0755)
+	os.MkdirAll(filepath.Join(root, "a", "b"), 0755)
+	ioutil.WriteFile(filepath.Join(root, "top"), []byte("1"), 0644)
+	ioutil.WriteFile(filepath.Join(root, "a", "mid"), []byte("2"), 0644)
+	ioutil.WriteFile(filepath.Join(root, "a", "b", "deep"), []byte("3"), 0644)
+	base := filepath.Base(root)
+
+	tests := []struct {
+		name     string
+		maxDepth int
+		expected []string
+	}{
+		{name: "No limit",
+			maxDepth: 0,
+			expected: []string{"D0755 0 " + base, "D0755 0 a", "D0755 0 b", "C0644 1 deep", "E", "C0644 1 mid", "E", "C0644 1 top", "E"},
+		},
+		{name: "Only the source's entries",
+			maxDepth: 1,
+			expected: []string{"D0755 0 " + base, "D0755 0 a", "E", "C0644 1 top", "E"},
+		},
+		{name: "Two levels",
+			maxDepth: 2,
+			expected: []string{"D0755 0 " + base, "D0755 0 a", "D0755 0 b", "E", "C0644 1 mid", "E", "C0644 1 top", "E"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			copier := NewSecureCopier()
+			copier.Sort = SortName
+			copier.MaxDepth = tt.maxDepth
+			stream, _ := uploadTree(t, &copier, root)
+			got := records(stream)
+			if !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("Value received: %v expected %v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestReadDirError(t *testing.T) {
+	root, err := ioutil.TempDir("", "scpgo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	dir, err := os.Open(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a closed handle fails to list, like a directory going away
+	dir.Close()
+
+	for _, mode := range []string{SortNone, SortName, SortSize} {
+		t.Run(mode, func(t *testing.T) {
+			copier := NewSecureCopier()
+			copier.errPipe = ioutil.Discard
+			copier.Sort = mode
+			err := copier.readDir(dir, root, func(fi os.FileInfo) error { return nil })
+			if err != nil {
+				t.Errorf("Unexpected error: %v", err)
+			}
+			if !copier.walkErrors[root] {
+				t.Errorf("Expected %s to be reported", root)
+			}
+			if copier.reportWalkErrors() == nil {
+				t.Errorf("Expected the upload to fail in the end")
+			}
+		})
+	}
+}
+
+func TestProcessDirUnreadable(t *testing.T) {
+	if os.Geteuid() == 0 {
+		t.Skip("root reads anything")
+	}
+	root, err := ioutil.TempDir("", "scpgo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	locked := filepath.Join(root, "locked")
+	os.Mkdir(locked, 0755)
+	ioutil.WriteFile(filepath.Join(root, "secret"), []byte("s"), 0)
+	ioutil.WriteFile(filepath.Join(root, "open"), []byte("o"), 0644)
+	os.Chmod(locked, 0)
+	defer os.Chmod(locked, 0755)
+
+	copier := NewSecureCopier()
+	stream, warnings := uploadTree(t, &copier, root)
+	if !strings.Contains(stream, "C0644 1 open") {
+		t.Errorf("Expected the readable file in stream %q", stream)
+	}
+	if strings.Contains(stream, "locked") || strings.Contains(stream, "secret") {
+		t.Errorf("Did not expect unreadable entries in stream %q", stream)
+	}
+	if len(copier.walkErrors) != 2 {
+		t.Errorf("Value received: %v expected %v (%s)", len(copier.walkErrors), 2, warnings)
+	}
+}
@@ -16,12 +16,67 @@ package sshagent
 
 import (
 	"errors"
+	"fmt"
 	"golang.org/x/crypto/ssh"
 	"golang.org/x/crypto/ssh/agent"
 	"net"
 	"os"
 )
 
+// Client A connection to a running ssh-agent
+type Client struct {
+	conn  net.Conn
+	agent agent.ExtendedAgent
+}
+
+// DialDefault Connects to the agent in SSH_AUTH_SOCK
+func DialDefault() (*Client, error) {
+	sshAuthSock := os.Getenv("SSH_AUTH_SOCK")
+	if sshAuthSock != "" {
+		return Dial(sshAuthSock)
+	}
+	return nil, errors.New("Could not load ssh-agent because SSH_AUTH_SOCK not available")
+}
+
+// Dial Connects to the agent listening on address
+func Dial(address string) (*Client, error) {
+	conn, err := net.Dial("unix", address)
+	if err != nil {
+		return nil, err
+	}
+	return &Client{conn: conn, agent: agent.NewClient(conn)}, nil
+}
+
+// Close Closes the agent socket. Signers from the client stop working.
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+// Signers Returns the agent keys that pass every filter, in agent order
+func (c *Client) Signers(filters ...*Filter) ([]ssh.Signer, error) {
+	keys, err := c.agent.List()
+	if err != nil {
+		return nil, fmt.Errorf("Could not list agent keys: %v", err)
+	}
+	wanted := map[string]bool{}
+	for _, key := range keys {
+		if matchesAll(filters, key) {
+			wanted[string(key.Marshal())] = true
+		}
+	}
+	all, err := c.agent.Signers()
+	if err != nil {
+		return nil, fmt.Errorf("Could not list agent keys: %v", err)
+	}
+	var signers []ssh.Signer
+	for _, signer := range all {
+		if wanted[string(signer.PublicKey().Marshal())] {
+			signers = append(signers, signer)
+		}
+	}
+	return signers, nil
+}
+
 // AgentClientDefault Returns the default ssh agent client signer
 func AgentClientDefault() ([]ssh.Signer, error) {
 	sshAuthSock := os.Getenv("SSH_AUTH_SOCK")
@@ -32,17 +87,17 @@ func AgentClientDefault() ([]ssh.Signer, error) {
 
 }
 
-// AgentClient Returns an ssh agent client signer
+// AgentClient Returns an ssh agent client signer. The agent socket stays
+// open for the signers to use; Dial returns a Client that can be closed.
 func AgentClient(address string) ([]ssh.Signer, error) {
-	var signers []ssh.Signer
-	agentClient, err := net.Dial("unix", address)
+	c, err := Dial(address)
 	if err != nil {
 		return nil, err
 	}
-	sshAgent := agent.NewClient(agentClient)
-	aSigners, _ := sshAgent.Signers()
-	for _, signer := range aSigners {
-		signers = append(signers, signer)
+	signers, err := c.Signers()
+	if err != nil {
+		c.Close()
+		return nil, err
 	}
 	return signers, nil
 }
@@ -0,0 +1,106 @@
+// Copyright © 2017 Ricardo Aravena <raravena@branch.io>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sshagent
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/raravena80/scpgo/sshkeys"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// Filter Selects agent keys by fingerprint, comment or public key file
+type Filter struct {
+	fingerprints map[string]bool
+	comments     map[string]bool
+	keys         map[string]bool
+}
+
+// NewFilter Builds a filter from patterns. A pattern starting with SHA256:
+// or MD5: is a fingerprint; one naming a public key, or a private key whose
+// public half can be read, matches that key; anything else is a comment.
+func NewFilter(patterns []string) *Filter {
+	f := &Filter{
+		fingerprints: map[string]bool{},
+		comments:     map[string]bool{},
+		keys:         map[string]bool{},
+	}
+	for _, pattern := range patterns {
+		switch {
+		case strings.HasPrefix(pattern, "SHA256:"):
+			f.fingerprints[strings.TrimRight(pattern, "=")] = true
+		case strings.HasPrefix(pattern, "MD5:"):
+			f.fingerprints[strings.ToLower(strings.TrimPrefix(pattern, "MD5:"))] = true
+		default:
+			if pub := publicKeyOf(pattern); pub != nil {
+				f.keys[string(pub.Marshal())] = true
+			}
+			// ssh-add uses the key file as comment
+			f.comments[pattern] = true
+		}
+	}
+	return f
+}
+
+// Match Reports whether an agent key is selected by the filter
+func (f *Filter) Match(key *agent.Key) bool {
+	if f.comments[key.Comment] || f.keys[string(key.Marshal())] {
+		return true
+	}
+	// a certificate goes with the key it certifies
+	if pub, err := ssh.ParsePublicKey(key.Blob); err == nil {
+		if cert, ok := pub.(*ssh.Certificate); ok && f.keys[string(cert.Key.Marshal())] {
+			return true
+		}
+	}
+	return f.fingerprints[ssh.FingerprintSHA256(key)] || f.fingerprints[ssh.FingerprintLegacyMD5(key)]
+}
+
+func matchesAll(filters []*Filter, key *agent.Key) bool {
+	for _, f := range filters {
+		if f != nil && !f.Match(key) {
+			return false
+		}
+	}
+	return true
+}
+
+// publicKeyOf Reads the public key from a .pub file, the .pub file next to a
+// private key or the private key itself. It returns nil if none works.
+func publicKeyOf(file string) ssh.PublicKey {
+	if _, err := os.Stat(file); err != nil {
+		return nil
+	}
+	for _, name := range []string{file, file + ".pub"} {
+		b, err := ioutil.ReadFile(name)
+		if err != nil {
+			continue
+		}
+		if pub, _, _, _, err := ssh.ParseAuthorizedKey(b); err == nil {
+			return pub
+		}
+		signer, err := sshkeys.ParsePrivateKey(b)
+		if err == nil {
+			return signer.PublicKey()
+		}
+		if missing, ok := err.(*ssh.PassphraseMissingError); ok && missing.PublicKey != nil {
+			return missing.PublicKey
+		}
+	}
+	return nil
+}
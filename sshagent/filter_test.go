@@ -0,0 +1,69 @@
+// Copyright © 2017 Ricardo Aravena <raravena@branch.io>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sshagent
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+func TestFilterMatch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "scpgo-agent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	keyFile := writeTestKey(t, dir, "")
+	added, err := LoadKey(keyFile, 0, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(added.PrivateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub := signer.PublicKey()
+	err = ioutil.WriteFile(filepath.Join(dir, "key.pub"), ssh.MarshalAuthorizedKey(pub), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := &agent.Key{Format: pub.Type(), Blob: pub.Marshal(), Comment: "ci@example.com"}
+
+	tests := []struct {
+		name     string
+		patterns []string
+		expected bool
+	}{
+		{name: "SHA256 fingerprint", patterns: []string{ssh.FingerprintSHA256(pub)}, expected: true},
+		{name: "MD5 fingerprint", patterns: []string{"MD5:" + ssh.FingerprintLegacyMD5(pub)}, expected: true},
+		{name: "Comment", patterns: []string{"ci@example.com"}, expected: true},
+		{name: "Public key file", patterns: []string{filepath.Join(dir, "key.pub")}, expected: true},
+		{name: "Private key file", patterns: []string{keyFile}, expected: true},
+		{name: "Other key", patterns: []string{"SHA256:AAAA", "other"}, expected: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			returned := NewFilter(tt.patterns).Match(key)
+			if returned != tt.expected {
+				t.Errorf("Value received: %v expected %v", returned, tt.expected)
+			}
+		})
+	}
+}
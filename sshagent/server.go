@@ -0,0 +1,210 @@
+// Copyright © 2017 Ricardo Aravena <raravena@branch.io>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sshagent
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/raravena80/scpgo/pwauth"
+	"github.com/raravena80/scpgo/sshkeys"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// confirmPrompt Asks whether a key may be used for a signature
+var confirmPrompt = pwauth.ConfirmPrompt
+
+// passphrasePrompt Asks for the passphrase of an encrypted key
+var passphrasePrompt = pwauth.KeyPassphrasePrompt
+
+// confirmAgent Adds confirm-before-use to the in-memory keyring, which
+// refuses that constraint on its own
+type confirmAgent struct {
+	agent.ExtendedAgent
+	mu      sync.Mutex
+	confirm map[string]bool
+}
+
+// NewKeyring Returns an in-memory agent that supports key lifetimes and
+// confirm-before-use
+func NewKeyring() agent.ExtendedAgent {
+	return &confirmAgent{
+		ExtendedAgent: agent.NewKeyring().(agent.ExtendedAgent),
+		confirm:       map[string]bool{},
+	}
+}
+
+func (a *confirmAgent) Add(key agent.AddedKey) error {
+	confirm := key.ConfirmBeforeUse
+	key.ConfirmBeforeUse = false
+	err := a.ExtendedAgent.Add(key)
+	if err != nil {
+		return err
+	}
+	signer, err := ssh.NewSignerFromKey(key.PrivateKey)
+	if err != nil {
+		return err
+	}
+	blob := signer.PublicKey().Marshal()
+	if key.Certificate != nil {
+		blob = key.Certificate.Marshal()
+	}
+	a.mu.Lock()
+	a.confirm[string(blob)] = confirm
+	a.mu.Unlock()
+	return nil
+}
+
+// allowed Asks the user before a confirm-before-use key signs anything
+func (a *confirmAgent) allowed(key ssh.PublicKey) error {
+	a.mu.Lock()
+	confirm := a.confirm[string(key.Marshal())]
+	a.mu.Unlock()
+	if !confirm {
+		return nil
+	}
+	ok, err := confirmPrompt(fmt.Sprintf("Allow use of key %s? (yes/no) ", ssh.FingerprintSHA256(key)))
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errors.New("agent: key use refused")
+	}
+	return nil
+}
+
+func (a *confirmAgent) Sign(key ssh.PublicKey, data []byte) (*ssh.Signature, error) {
+	if err := a.allowed(key); err != nil {
+		return nil, err
+	}
+	return a.ExtendedAgent.Sign(key, data)
+}
+
+func (a *confirmAgent) SignWithFlags(key ssh.PublicKey, data []byte, flags agent.SignatureFlags) (*ssh.Signature, error) {
+	if err := a.allowed(key); err != nil {
+		return nil, err
+	}
+	return a.ExtendedAgent.SignWithFlags(key, data, flags)
+}
+
+// LoadKey Reads a private key for the agent, asking once for its passphrase.
+// A certificate in <keyFile>-cert.pub is attached to it.
+func LoadKey(keyFile string, lifetime time.Duration, confirm bool) (agent.AddedKey, error) {
+	added := agent.AddedKey{
+		Comment:          keyFile,
+		LifetimeSecs:     uint32(lifetime / time.Second),
+		ConfirmBeforeUse: confirm,
+	}
+	pem, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		return added, err
+	}
+	added.PrivateKey, err = sshkeys.ParseRawPrivateKey(pem)
+	if _, ok := err.(*ssh.PassphraseMissingError); ok {
+		pass, err := passphrasePrompt(keyFile)
+		if err != nil {
+			return added, err
+		}
+		added.PrivateKey, err = sshkeys.ParseRawPrivateKeyWithPassphrase(pem, []byte(pass))
+		if err != nil {
+			return added, fmt.Errorf("Could not decrypt %s: %v", keyFile, err)
+		}
+	} else if err != nil {
+		return added, fmt.Errorf("Could not parse %s: %v", keyFile, err)
+	}
+	if b, err := ioutil.ReadFile(keyFile + "-cert.pub"); err == nil {
+		if pub, _, _, _, err := ssh.ParseAuthorizedKey(b); err == nil {
+			if cert, ok := pub.(*ssh.Certificate); ok {
+				added.Certificate = cert
+			}
+		}
+	}
+	return added, nil
+}
+
+// Listen Opens the agent socket, readable by the current user only
+func Listen(socket string) (net.Listener, error) {
+	err := os.MkdirAll(filepath.Dir(socket), 0700)
+	if err != nil {
+		return nil, err
+	}
+	err = removeStaleSocket(socket)
+	if err != nil {
+		return nil, err
+	}
+	l, err := listenPrivate(socket)
+	if err != nil {
+		return nil, err
+	}
+	err = os.Chmod(socket, 0600)
+	if err != nil {
+		l.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+// removeStaleSocket Removes a socket left behind by an agent that died.
+// Anything else at that path, or a socket still answering, is left alone.
+func removeStaleSocket(socket string) error {
+	fi, err := os.Lstat(socket)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if fi.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("%s exists and is not a socket", socket)
+	}
+	if c, err := net.Dial("unix", socket); err == nil {
+		c.Close()
+		return fmt.Errorf("An agent is already listening on %s", socket)
+	}
+	return os.Remove(socket)
+}
+
+// Serve Answers agent requests on every connection until the listener closes
+func Serve(l net.Listener, keyring agent.Agent) error {
+	for {
+		c, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go func() {
+			defer c.Close()
+			agent.ServeAgent(keyring, c)
+		}()
+	}
+}
+
+// WatchParent Closes done once the process that started us has exited
+func WatchParent(interval time.Duration, done chan<- struct{}) {
+	ppid := os.Getppid()
+	for {
+		time.Sleep(interval)
+		if os.Getppid() != ppid {
+			close(done)
+			return
+		}
+	}
+}
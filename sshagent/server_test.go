@@ -0,0 +1,171 @@
+// Copyright © 2017 Ricardo Aravena <raravena@branch.io>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sshagent
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// writeTestKey Writes a new ed25519 key, encrypted when passphrase is set
+func writeTestKey(t *testing.T, dir, passphrase string) string {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var block *pem.Block
+	if passphrase == "" {
+		block, err = ssh.MarshalPrivateKey(priv, "")
+	} else {
+		block, err = ssh.MarshalPrivateKeyWithPassphrase(priv, "", []byte(passphrase))
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyFile := filepath.Join(dir, "id_ed25519")
+	err = ioutil.WriteFile(keyFile, pem.EncodeToMemory(block), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return keyFile
+}
+
+func TestServe(t *testing.T) {
+	tests := []struct {
+		name       string
+		passphrase string
+		confirm    bool
+		answer     bool
+		expected   bool
+	}{
+		{name: "Plain key", expected: true},
+		{name: "Encrypted key", passphrase: "secret", expected: true},
+		{name: "Confirmed use", confirm: true, answer: true, expected: true},
+		{name: "Refused use", confirm: true, answer: false, expected: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "scpgo-agent")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+			prompts := 0
+			passphrasePrompt = func(string) (string, error) {
+				prompts++
+				return tt.passphrase, nil
+			}
+			confirmPrompt = func(string) (bool, error) { return tt.answer, nil }
+			key, err := LoadKey(writeTestKey(t, dir, tt.passphrase), 0, tt.confirm)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.passphrase != "" && prompts != 1 {
+				t.Errorf("Value received: %v expected %v", prompts, 1)
+			}
+			keyring := NewKeyring()
+			err = keyring.Add(key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			socket := filepath.Join(dir, "sock", "agent.sock")
+			l, err := Listen(socket)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer l.Close()
+			go Serve(l, keyring)
+
+			conn, err := net.Dial("unix", socket)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			signers, err := agent.NewClient(conn).Signers()
+			if err != nil || len(signers) != 1 {
+				t.Fatalf("Value received: %v %v expected %v", len(signers), err, 1)
+			}
+			_, err = signers[0].Sign(rand.Reader, []byte("data"))
+			if returned := err == nil; returned != tt.expected {
+				t.Errorf("Value received: %v expected %v", returned, tt.expected)
+			}
+		})
+	}
+}
+
+func TestListen(t *testing.T) {
+	tests := []struct {
+		name     string
+		existing string
+		expected bool
+	}{
+		{name: "New socket", expected: true},
+		{name: "Stale socket", existing: "stale", expected: true},
+		{name: "Live socket", existing: "live", expected: false},
+		{name: "Regular file", existing: "file", expected: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "scpgo")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+			socket := filepath.Join(dir, "agent.sock")
+			switch tt.existing {
+			case "stale", "live":
+				l, err := net.Listen("unix", socket)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if tt.existing == "stale" {
+					l.(*net.UnixListener).SetUnlinkOnClose(false)
+					l.Close()
+				} else {
+					defer l.Close()
+				}
+			case "file":
+				ioutil.WriteFile(socket, []byte("keep"), 0600)
+			}
+			l, err := Listen(socket)
+			if returned := err == nil; returned != tt.expected {
+				t.Fatalf("Value received: %v expected %v (%v)", returned, tt.expected, err)
+			}
+			if err != nil {
+				if _, err := os.Lstat(socket); err != nil {
+					t.Errorf("Expected %s to be left alone: %v", socket, err)
+				}
+				return
+			}
+			defer l.Close()
+			fi, err := os.Stat(socket)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if fi.Mode().Perm() != 0600 {
+				t.Errorf("Value received: %v expected %v", fi.Mode().Perm(), os.FileMode(0600))
+			}
+		})
+	}
+}
@@ -0,0 +1,232 @@
+// Copyright © 2017 Ricardo Aravena <raravena@branch.io>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sshconn
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// Crypto policy presets
+const (
+	// PolicyModern Only AEAD ciphers, curve25519 and ETM MACs
+	PolicyModern = "modern"
+	// PolicyCompat The defaults plus the legacy algorithms old gear needs
+	PolicyCompat = "compat"
+	// PolicyFIPSLike Only AES, NIST curves, SHA-2 and RSA/ECDSA host keys
+	PolicyFIPSLike = "fips-like"
+)
+
+// Algorithm kinds, as in ssh -Q
+const (
+	AlgoCipher  = "cipher"
+	AlgoKex     = "kex"
+	AlgoMAC     = "mac"
+	AlgoHostKey = "key"
+)
+
+// Policies The crypto policy presets
+var Policies = []string{PolicyModern, PolicyCompat, PolicyFIPSLike}
+
+// policyAlgorithms Returns the algorithm lists of a preset, "" meaning the
+// x/crypto/ssh defaults
+func policyAlgorithms(policy string) (ssh.Algorithms, error) {
+	supported := ssh.SupportedAlgorithms()
+	switch policy {
+	case "":
+		return supported, nil
+	case PolicyModern:
+		return ssh.Algorithms{
+			Ciphers:      []string{ssh.CipherChaCha20Poly1305, ssh.CipherAES256GCM, ssh.CipherAES128GCM},
+			KeyExchanges: []string{ssh.KeyExchangeMLKEM768X25519, ssh.KeyExchangeCurve25519},
+			MACs:         []string{ssh.HMACSHA512ETM, ssh.HMACSHA256ETM},
+			HostKeys: []string{
+				ssh.CertAlgoED25519v01, ssh.CertAlgoECDSA256v01, ssh.CertAlgoRSASHA512v01,
+				ssh.KeyAlgoED25519, ssh.KeyAlgoECDSA256, ssh.KeyAlgoECDSA384, ssh.KeyAlgoECDSA521,
+				ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256,
+			},
+		}, nil
+	case PolicyCompat:
+		insecure := ssh.InsecureAlgorithms()
+		return ssh.Algorithms{
+			Ciphers:      append(supported.Ciphers, insecure.Ciphers...),
+			KeyExchanges: append(supported.KeyExchanges, insecure.KeyExchanges...),
+			MACs:         append(supported.MACs, insecure.MACs...),
+			HostKeys:     append(supported.HostKeys, insecure.HostKeys...),
+		}, nil
+	case PolicyFIPSLike:
+		return ssh.Algorithms{
+			Ciphers: []string{ssh.CipherAES256GCM, ssh.CipherAES128GCM, ssh.CipherAES256CTR, ssh.CipherAES192CTR, ssh.CipherAES128CTR},
+			KeyExchanges: []string{
+				ssh.KeyExchangeECDHP256, ssh.KeyExchangeECDHP384, ssh.KeyExchangeECDHP521,
+				ssh.KeyExchangeDH16SHA512, ssh.KeyExchangeDH14SHA256,
+			},
+			MACs: []string{ssh.HMACSHA256ETM, ssh.HMACSHA512ETM, ssh.HMACSHA256, ssh.HMACSHA512},
+			HostKeys: []string{
+				ssh.CertAlgoECDSA256v01, ssh.CertAlgoECDSA384v01, ssh.CertAlgoECDSA521v01,
+				ssh.CertAlgoRSASHA512v01, ssh.CertAlgoRSASHA256v01,
+				ssh.KeyAlgoECDSA256, ssh.KeyAlgoECDSA384, ssh.KeyAlgoECDSA521,
+				ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256,
+			},
+		}, nil
+	}
+	return ssh.Algorithms{}, fmt.Errorf("Unknown crypto policy %q, use one of: %s", policy, strings.Join(Policies, ", "))
+}
+
+// ListAlgorithms Returns every algorithm of a kind that can be enabled,
+// the insecure ones last
+func ListAlgorithms(kind string) ([]string, error) {
+	supported, insecure := ssh.SupportedAlgorithms(), ssh.InsecureAlgorithms()
+	switch kind {
+	case AlgoCipher:
+		return append(supported.Ciphers, insecure.Ciphers...), nil
+	case AlgoKex:
+		return append(supported.KeyExchanges, insecure.KeyExchanges...), nil
+	case AlgoMAC:
+		return append(supported.MACs, insecure.MACs...), nil
+	case AlgoHostKey:
+		return append(supported.HostKeys, insecure.HostKeys...), nil
+	}
+	return nil, fmt.Errorf("Unknown algorithm kind %q, use one of: %s, %s, %s, %s", kind, AlgoCipher, AlgoKex, AlgoMAC, AlgoHostKey)
+}
+
+// applyAlgorithms Applies a list given like in ssh_config to base. A list
+// starting with + appends, with - removes (wildcards allowed) and with ^
+// prepends; anything else replaces base.
+func applyAlgorithms(kind string, base, spec []string) ([]string, error) {
+	var names []string
+	for _, name := range spec {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return base, nil
+	}
+	known, err := ListAlgorithms(kind)
+	if err != nil {
+		return nil, err
+	}
+	op := names[0][0]
+	if op == '+' || op == '-' || op == '^' {
+		names[0] = names[0][1:]
+	} else {
+		op = '='
+	}
+	for _, name := range names {
+		if op == '-' {
+			continue
+		}
+		if !contains(known, name) {
+			return nil, fmt.Errorf("Unsupported %s algorithm %q, see --list-algorithms %s", kind, name, kind)
+		}
+	}
+	var algos []string
+	switch op {
+	case '=':
+		algos = names
+	case '+':
+		algos = append(append([]string{}, base...), names...)
+	case '^':
+		algos = append(append([]string{}, names...), base...)
+	case '-':
+		for _, algo := range base {
+			removed := false
+			for _, pattern := range names {
+				if wildcardMatch(pattern, algo) {
+					removed = true
+				}
+			}
+			if !removed {
+				algos = append(algos, algo)
+			}
+		}
+	}
+	algos = unique(algos)
+	if len(algos) == 0 {
+		return nil, fmt.Errorf("No %s algorithms left after %q", kind, strings.Join(spec, ","))
+	}
+	return algos, nil
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+func unique(list []string) []string {
+	seen := map[string]bool{}
+	var out []string
+	for _, item := range list {
+		if !seen[item] {
+			seen[item] = true
+			out = append(out, item)
+		}
+	}
+	return out
+}
+
+// preferKnown Orders algos like known, the host key algorithms we have
+// keys for, keeping the rest in their order at the end
+func preferKnown(algos, known []string) []string {
+	rank := map[string]int{}
+	for i, algo := range known {
+		rank[algo] = i + 1
+	}
+	sorted := append([]string{}, algos...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		ri, rj := rank[sorted[i]], rank[sorted[j]]
+		return ri != 0 && (rj == 0 || ri < rj)
+	})
+	return sorted
+}
+
+// cryptoConfig Fills the algorithms of a client config from the policy and
+// the lists in cfg. Nothing is changed when none of them is set.
+func cryptoConfig(cfg Config, clientConfig *ssh.ClientConfig) error {
+	if cfg.CryptoPolicy == "" && len(cfg.Ciphers) == 0 && len(cfg.KeyExchanges) == 0 &&
+		len(cfg.MACs) == 0 && len(cfg.HostKeyAlgorithms) == 0 {
+		return nil
+	}
+	base, err := policyAlgorithms(cfg.CryptoPolicy)
+	if err != nil {
+		return err
+	}
+	clientConfig.Ciphers, err = applyAlgorithms(AlgoCipher, base.Ciphers, cfg.Ciphers)
+	if err != nil {
+		return err
+	}
+	clientConfig.KeyExchanges, err = applyAlgorithms(AlgoKex, base.KeyExchanges, cfg.KeyExchanges)
+	if err != nil {
+		return err
+	}
+	clientConfig.MACs, err = applyAlgorithms(AlgoMAC, base.MACs, cfg.MACs)
+	if err != nil {
+		return err
+	}
+	hostKeys, err := applyAlgorithms(AlgoHostKey, base.HostKeys, cfg.HostKeyAlgorithms)
+	if err != nil {
+		return err
+	}
+	clientConfig.HostKeyAlgorithms = preferKnown(hostKeys, clientConfig.HostKeyAlgorithms)
+	return nil
+}
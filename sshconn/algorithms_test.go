@@ -0,0 +1,158 @@
+// Copyright © 2017 Ricardo Aravena <raravena@branch.io>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sshconn
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"net"
+	"reflect"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestApplyAlgorithms(t *testing.T) {
+	base := []string{ssh.CipherAES128GCM, ssh.CipherAES256CTR, ssh.CipherAES128CTR}
+	tests := []struct {
+		name     string
+		spec     []string
+		expected []string
+		err      bool
+	}{
+		{name: "Default", spec: nil, expected: base},
+		{name: "Replace", spec: []string{ssh.CipherAES256CTR}, expected: []string{ssh.CipherAES256CTR}},
+		{name: "Append", spec: []string{"+aes128-cbc", "3des-cbc"}, expected: append(append([]string{}, base...), "aes128-cbc", "3des-cbc")},
+		{name: "Prepend", spec: []string{"^" + ssh.CipherAES128CTR}, expected: []string{ssh.CipherAES128CTR, ssh.CipherAES128GCM, ssh.CipherAES256CTR}},
+		{name: "Remove wildcard", spec: []string{"-*-ctr"}, expected: []string{ssh.CipherAES128GCM}},
+		{name: "Unknown", spec: []string{"rot13"}, err: true},
+		{name: "Nothing left", spec: []string{"-*"}, err: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			returned, err := applyAlgorithms(AlgoCipher, base, tt.spec)
+			if (err != nil) != tt.err {
+				t.Fatalf("Value received: %v expected error %v", err, tt.err)
+			}
+			if !tt.err && !reflect.DeepEqual(returned, tt.expected) {
+				t.Errorf("Value received: %v expected %v", returned, tt.expected)
+			}
+		})
+	}
+}
+
+func TestPolicyAlgorithms(t *testing.T) {
+	for _, policy := range Policies {
+		t.Run(policy, func(t *testing.T) {
+			algos, err := policyAlgorithms(policy)
+			if err != nil {
+				t.Fatal(err)
+			}
+			lists := map[string][]string{
+				AlgoCipher:  algos.Ciphers,
+				AlgoKex:     algos.KeyExchanges,
+				AlgoMAC:     algos.MACs,
+				AlgoHostKey: algos.HostKeys,
+			}
+			for kind, list := range lists {
+				known, _ := ListAlgorithms(kind)
+				for _, algo := range list {
+					if !contains(known, algo) {
+						t.Errorf("Value received: %v expected a supported %s algorithm", algo, kind)
+					}
+				}
+			}
+		})
+	}
+	if _, err := policyAlgorithms("paranoid"); err == nil {
+		t.Errorf("Expected an error for an unknown policy")
+	}
+}
+
+func TestPreferKnown(t *testing.T) {
+	returned := preferKnown([]string{"a", "b", "c", "d"}, []string{"c", "x", "a"})
+	expected := []string{"c", "a", "b", "d"}
+	if !reflect.DeepEqual(returned, expected) {
+		t.Errorf("Value received: %v expected %v", returned, expected)
+	}
+}
+
+// handshake Connects a client using cfg to a server limited to ciphers
+func handshake(t *testing.T, cfg Config, ciphers []string) error {
+	// an ECDSA host key suits every policy
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hostKey, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serverConfig := &ssh.ServerConfig{NoClientAuth: true}
+	serverConfig.Ciphers = ciphers
+	serverConfig.AddHostKey(hostKey)
+	clientConfig := &ssh.ClientConfig{User: "test", HostKeyCallback: ssh.InsecureIgnoreHostKey()}
+	err = cryptoConfig(cfg, clientConfig)
+	if err != nil {
+		return err
+	}
+	// net.Pipe would deadlock with both ends sending their version first
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		c, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		conn, _, _, err := ssh.NewServerConn(c, serverConfig)
+		if err == nil {
+			conn.Close()
+		}
+	}()
+	client, err := ssh.Dial("tcp", l.Addr().String(), clientConfig)
+	if err == nil {
+		client.Close()
+	}
+	return err
+}
+
+func TestCryptoConfig(t *testing.T) {
+	tests := []struct {
+		name     string
+		cfg      Config
+		server   []string
+		expected bool
+	}{
+		{name: "Defaults", server: []string{ssh.CipherAES128CTR}, expected: true},
+		{name: "FIPS-like against CTR", cfg: Config{CryptoPolicy: PolicyFIPSLike}, server: []string{ssh.CipherAES128CTR}, expected: true},
+		{name: "Modern against CTR", cfg: Config{CryptoPolicy: PolicyModern}, server: []string{ssh.CipherAES128CTR}, expected: false},
+		{name: "Compat against CBC", cfg: Config{CryptoPolicy: PolicyCompat}, server: []string{"aes128-cbc"}, expected: true},
+		{name: "Added CBC", cfg: Config{Ciphers: []string{"+aes128-cbc"}}, server: []string{"aes128-cbc"}, expected: true},
+		{name: "Defaults against CBC", server: []string{"aes128-cbc"}, expected: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := handshake(t, tt.cfg, tt.server)
+			if returned := err == nil; returned != tt.expected {
+				t.Errorf("Value received: %v (%v) expected %v", returned, err, tt.expected)
+			}
+		})
+	}
+}
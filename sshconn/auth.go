@@ -0,0 +1,96 @@
+// Copyright © 2017 Ricardo Aravena <raravena@branch.io>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sshconn
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/raravena80/scpgo/pwauth"
+	"golang.org/x/crypto/ssh"
+)
+
+// Authentication methods, named as in ssh_config
+const (
+	AuthPublicKey           = "publickey"
+	AuthKeyboardInteractive = "keyboard-interactive"
+	AuthPassword            = "password"
+)
+
+// answerPrompt Reads the answer to a server prompt, hidden unless echo is set
+var answerPrompt = pwauth.AnswerPrompt
+
+// passwordPrompt Asks for the password of userName@host
+var passwordPrompt = func(userName, host string) (string, error) {
+	pp := pwauth.NewPasswordPrompt(userName, host)
+	return pp.Password(userName)
+}
+
+// keyboardInteractive Answers keyboard-interactive challenges, e.g. a PAM
+// password followed by a one time code, one server prompt at a time
+func keyboardInteractive(errPipe io.Writer) ssh.KeyboardInteractiveChallenge {
+	return func(name, instruction string, questions []string, echos []bool) ([]string, error) {
+		if name != "" {
+			fmt.Fprintln(errPipe, name)
+		}
+		if instruction != "" {
+			fmt.Fprintln(errPipe, instruction)
+		}
+		answers := make([]string, len(questions))
+		for i, question := range questions {
+			answer, err := answerPrompt(question, echos[i])
+			if err != nil {
+				return nil, err
+			}
+			answers[i] = answer
+		}
+		return answers, nil
+	}
+}
+
+// authMethods Builds the authentication methods in the order they should be
+// offered. The server may ask for several in turn (AuthenticationMethods),
+// which ssh handles by continuing down the list after a partial success.
+func authMethods(order []string, signers []ssh.Signer, userName, host string, password bool, errPipe io.Writer) ([]ssh.AuthMethod, error) {
+	if len(order) == 0 {
+		// keyboard-interactive only when asked for, it may prompt for codes
+		order = []string{AuthPublicKey}
+		if password {
+			order = append(order, AuthPassword)
+		}
+	}
+	auths := []ssh.AuthMethod{}
+	seen := map[string]bool{}
+	for _, method := range order {
+		if seen[method] {
+			continue
+		}
+		seen[method] = true
+		switch method {
+		case AuthPublicKey:
+			auths = append(auths, ssh.PublicKeys(signers...))
+		case AuthKeyboardInteractive:
+			auths = append(auths, ssh.KeyboardInteractive(keyboardInteractive(errPipe)))
+		case AuthPassword:
+			// only prompt if the server gets as far as asking
+			auths = append(auths, ssh.PasswordCallback(func() (string, error) {
+				return passwordPrompt(userName, host)
+			}))
+		default:
+			return nil, fmt.Errorf("Unknown authentication method '%s' (use %s, %s or %s)", method, AuthPublicKey, AuthKeyboardInteractive, AuthPassword)
+		}
+	}
+	return auths, nil
+}
@@ -0,0 +1,128 @@
+// Copyright © 2017 Ricardo Aravena <raravena@branch.io>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sshconn
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"net"
+	"reflect"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// mfaServer Accepts one connection needing a public key, then a password
+// and a one time code over keyboard-interactive
+func mfaServer(t *testing.T, key ssh.PublicKey) (string, <-chan error) {
+	config := &ssh.ServerConfig{
+		PublicKeyCallback: func(conn ssh.ConnMetadata, offered ssh.PublicKey) (*ssh.Permissions, error) {
+			if !bytes.Equal(offered.Marshal(), key.Marshal()) {
+				return nil, errors.New("unknown key")
+			}
+			return nil, &ssh.PartialSuccessError{Next: ssh.ServerAuthCallbacks{
+				KeyboardInteractiveCallback: func(conn ssh.ConnMetadata, client ssh.KeyboardInteractiveChallenge) (*ssh.Permissions, error) {
+					answers, err := client("", "Two factors", []string{"Password: ", "Code: "}, []bool{false, true})
+					if err != nil {
+						return nil, err
+					}
+					if !reflect.DeepEqual(answers, []string{"secret", "123456"}) {
+						return nil, errors.New("wrong answers")
+					}
+					return nil, nil
+				},
+			}}
+		},
+	}
+	config.AddHostKey(newTestSigner(t))
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error, 1)
+	go func() {
+		defer l.Close()
+		c, err := l.Accept()
+		if err != nil {
+			done <- err
+			return
+		}
+		defer c.Close()
+		_, _, _, err = ssh.NewServerConn(c, config)
+		done <- err
+	}()
+	return l.Addr().String(), done
+}
+
+func TestAuthMethodsMultiFactor(t *testing.T) {
+	saveAnswer := answerPrompt
+	defer func() { answerPrompt = saveAnswer }()
+	answerPrompt = func(prompt string, echo bool) (string, error) {
+		switch {
+		case prompt == "Password: " && !echo:
+			return "secret", nil
+		case prompt == "Code: " && echo:
+			return "123456", nil
+		}
+		return "", errors.New("unexpected prompt " + prompt)
+	}
+	signer := newTestSigner(t)
+	addr, done := mfaServer(t, signer.PublicKey())
+
+	auths, err := authMethods([]string{AuthPublicKey, AuthKeyboardInteractive}, []ssh.Signer{signer}, "user", "host", false, ioutil.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := ssh.Dial("tcp", addr, &ssh.ClientConfig{
+		User:            "user",
+		Auth:            auths,
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	client.Close()
+	if err := <-done; err != nil {
+		t.Errorf("Server error: %v", err)
+	}
+}
+
+func TestAuthMethods(t *testing.T) {
+	tests := []struct {
+		name     string
+		order    []string
+		password bool
+		expected int
+		fails    bool
+	}{
+		{name: "Default", expected: 1},
+		{name: "Default with password", password: true, expected: 2},
+		{name: "Explicit order", order: []string{AuthPassword, AuthPublicKey, AuthPassword}, expected: 2},
+		{name: "Unknown method", order: []string{"gssapi-with-mic"}, fails: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			returned, err := authMethods(tt.order, nil, "user", "host", tt.password, ioutil.Discard)
+			if (err != nil) != tt.fails {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if len(returned) != tt.expected {
+				t.Errorf("Value received: %v expected %v", len(returned), tt.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,116 @@
+// Copyright © 2017 Ricardo Aravena <raravena@branch.io>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sshconn
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// certFiles Returns the explicit certificates followed by the -cert.pub
+// files found next to the identity files
+func certFiles(idFiles, explicit []string) []string {
+	files := append([]string{}, explicit...)
+	for _, idFile := range idFiles {
+		certFile := idFile + "-cert.pub"
+		if _, err := os.Stat(certFile); err == nil {
+			files = append(files, certFile)
+		}
+	}
+	return files
+}
+
+func loadCertificate(certFile string) (*ssh.Certificate, error) {
+	b, err := ioutil.ReadFile(certFile)
+	if err != nil {
+		return nil, err
+	}
+	pub, _, _, _, err := ssh.ParseAuthorizedKey(b)
+	if err != nil {
+		return nil, fmt.Errorf("Could not parse certificate %s: %v", certFile, err)
+	}
+	cert, ok := pub.(*ssh.Certificate)
+	if !ok {
+		return nil, fmt.Errorf("%s is not a certificate", certFile)
+	}
+	return cert, nil
+}
+
+// checkCertificate Makes sure a user certificate is valid at the given time
+func checkCertificate(cert *ssh.Certificate, now time.Time) error {
+	if cert.CertType != ssh.UserCert {
+		return fmt.Errorf("certificate %q is not a user certificate", cert.KeyId)
+	}
+	unix := uint64(now.Unix())
+	if unix < cert.ValidAfter {
+		return fmt.Errorf("certificate %q is not valid before %v", cert.KeyId, time.Unix(int64(cert.ValidAfter), 0))
+	}
+	if cert.ValidBefore != ssh.CertTimeInfinity && unix >= cert.ValidBefore {
+		return fmt.Errorf("certificate %q expired at %v", cert.KeyId, time.Unix(int64(cert.ValidBefore), 0))
+	}
+	return nil
+}
+
+// certSigners Puts certificate signers ahead of plain keys. Certificates
+// from files are paired with the loaded key they certify, and those that
+// aren't valid now are reported and left out.
+func certSigners(signers []ssh.Signer, certFiles []string, now time.Time, errPipe io.Writer) []ssh.Signer {
+	var certs, keys []ssh.Signer
+	for _, signer := range signers {
+		// agents hand out certificates like any other key
+		if cert, ok := signer.PublicKey().(*ssh.Certificate); ok {
+			if err := checkCertificate(cert, now); err != nil {
+				fmt.Fprintf(errPipe, "Skipping agent certificate: %v\n", err)
+				continue
+			}
+			certs = append(certs, signer)
+		} else {
+			keys = append(keys, signer)
+		}
+	}
+	for _, certFile := range certFiles {
+		cert, err := loadCertificate(certFile)
+		if err == nil {
+			err = checkCertificate(cert, now)
+		}
+		if err != nil {
+			fmt.Fprintf(errPipe, "Skipping %s: %v\n", certFile, err)
+			continue
+		}
+		found := false
+		for _, key := range keys {
+			if bytes.Equal(key.PublicKey().Marshal(), cert.Key.Marshal()) {
+				certSigner, err := ssh.NewCertSigner(cert, key)
+				if err != nil {
+					fmt.Fprintf(errPipe, "Skipping %s: %v\n", certFile, err)
+				} else {
+					certs = append(certs, certSigner)
+				}
+				found = true
+				break
+			}
+		}
+		if !found {
+			fmt.Fprintf(errPipe, "Skipping %s: no loaded key matches it\n", certFile)
+		}
+	}
+	return append(certs, keys...)
+}
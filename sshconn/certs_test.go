@@ -0,0 +1,128 @@
+// Copyright © 2017 Ricardo Aravena <raravena@branch.io>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sshconn
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func newTestSigner(t *testing.T) ssh.Signer {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signer
+}
+
+// newTestCert Signs a user certificate for key valid between after and before
+func newTestCert(t *testing.T, ca ssh.Signer, key ssh.PublicKey, certType uint32, after, before time.Time) *ssh.Certificate {
+	cert := &ssh.Certificate{
+		Key:         key,
+		KeyId:       "test",
+		CertType:    certType,
+		ValidAfter:  uint64(after.Unix()),
+		ValidBefore: uint64(before.Unix()),
+	}
+	if err := cert.SignCert(rand.Reader, ca); err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func TestCheckCertificate(t *testing.T) {
+	ca := newTestSigner(t)
+	key := newTestSigner(t).PublicKey()
+	now := time.Now()
+	tests := []struct {
+		name  string
+		cert  *ssh.Certificate
+		valid bool
+	}{
+		{name: "Valid", cert: newTestCert(t, ca, key, ssh.UserCert, now.Add(-time.Hour), now.Add(time.Hour)), valid: true},
+		{name: "Expired", cert: newTestCert(t, ca, key, ssh.UserCert, now.Add(-2*time.Hour), now.Add(-time.Hour))},
+		{name: "Not yet valid", cert: newTestCert(t, ca, key, ssh.UserCert, now.Add(time.Hour), now.Add(2*time.Hour))},
+		{name: "Host certificate", cert: newTestCert(t, ca, key, ssh.HostCert, now.Add(-time.Hour), now.Add(time.Hour))},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			returned := checkCertificate(tt.cert, now) == nil
+			if returned != tt.valid {
+				t.Errorf("Value received: %v expected %v", returned, tt.valid)
+			}
+		})
+	}
+}
+
+func TestCertSigners(t *testing.T) {
+	dir, err := ioutil.TempDir("", "scpgo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ca := newTestSigner(t)
+	key := newTestSigner(t)
+	other := newTestSigner(t)
+	now := time.Now()
+
+	validFile := filepath.Join(dir, "valid-cert.pub")
+	cert := newTestCert(t, ca, key.PublicKey(), ssh.UserCert, now.Add(-time.Hour), now.Add(time.Hour))
+	ioutil.WriteFile(validFile, ssh.MarshalAuthorizedKey(cert), 0644)
+	expiredFile := filepath.Join(dir, "expired-cert.pub")
+	expired := newTestCert(t, ca, key.PublicKey(), ssh.UserCert, now.Add(-2*time.Hour), now.Add(-time.Hour))
+	ioutil.WriteFile(expiredFile, ssh.MarshalAuthorizedKey(expired), 0644)
+	// a certificate held by an agent shows up as a signer of its own
+	agentCert, err := ssh.NewCertSigner(newTestCert(t, ca, other.PublicKey(), ssh.UserCert, now.Add(-time.Hour), now.Add(time.Hour)), other)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	returned := certSigners([]ssh.Signer{other, key, agentCert}, []string{expiredFile, validFile}, now, ioutil.Discard)
+	if len(returned) != 4 {
+		t.Fatalf("Value received: %v expected %v", len(returned), 4)
+	}
+	for i, expected := range []ssh.PublicKey{agentCert.PublicKey(), cert, other.PublicKey(), key.PublicKey()} {
+		if string(returned[i].PublicKey().Marshal()) != string(expected.Marshal()) {
+			t.Errorf("Unexpected signer at position %d: %s", i, returned[i].PublicKey().Type())
+		}
+	}
+}
+
+func TestCertFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "scpgo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	withCert := filepath.Join(dir, "id_a")
+	ioutil.WriteFile(withCert+"-cert.pub", []byte{}, 0644)
+	returned := certFiles([]string{withCert, filepath.Join(dir, "id_b")}, []string{"explicit-cert.pub"})
+	expected := []string{"explicit-cert.pub", withCert + "-cert.pub"}
+	if len(returned) != len(expected) || returned[0] != expected[0] || returned[1] != expected[1] {
+		t.Errorf("Value received: %v expected %v", returned, expected)
+	}
+}
@@ -16,26 +16,15 @@ package sshconn
 
 import (
 	"fmt"
-	homedir "github.com/mitchellh/go-homedir"
-	"github.com/raravena80/scpgo/pwauth"
-	"github.com/raravena80/scpgo/sshagent"
 	"golang.org/x/crypto/ssh"
-	"golang.org/x/crypto/ssh/knownhosts"
 	"io"
-	"io/ioutil"
 	"os"
 	"os/user"
 	"runtime"
 	"strings"
+	"time"
 )
 
-func loadKeyring(idFile string) (ssh.Signer, error) {
-	id, err := ioutil.ReadFile(idFile)
-	k, err := ssh.ParseRawPrivateKey(id)
-	s, err := ssh.NewSignerFromKey(k)
-	return s, err
-}
-
 // FillDefaultUsername Returns the default username according to the env
 func FillDefaultUsername(userName string) string {
 	if userName == "" {
@@ -54,67 +43,140 @@ func FillDefaultUsername(userName string) string {
 	return userName
 }
 
+// Config Options used to establish a connection
+type Config struct {
+	User                string
+	Host                string
+	Port                int
+	KeyFiles            []string
+	CertFiles           []string
+	Password            bool
+	AuthMethods         []string
+	CheckKnownHosts     bool
+	KnownHostsFiles     []string
+	StrictHostKeys      string
+	HostKeyPins         []string
+	IdentitiesOnly      bool
+	AgentKeys           []string
+	CryptoPolicy        string
+	Ciphers             []string
+	KeyExchanges        []string
+	MACs                []string
+	HostKeyAlgorithms   []string
+	ConnectTimeout      time.Duration
+	ServerAliveInterval time.Duration
+	ServerAliveCountMax int
+	StallTimeout        time.Duration
+	Monitor             *Monitor
+	Verbose             bool
+	ErrPipe             io.Writer
+}
+
 // Connect Main function that establishes connection
-func Connect(userName, host string, port int, idFile string, password bool, checkKnownHosts bool, verbose bool, errPipe io.Writer) (*ssh.Session, error) {
-	signers := []ssh.Signer{}
-	userName = FillDefaultUsername(userName)
-	if idFile != "" {
-		signer, err := loadKeyring(idFile)
-		if err != nil {
-			fmt.Fprintf(errPipe, "Error loading key file (%v)\n", err)
-		} else {
-			signers = append(signers, signer)
+func Connect(cfg Config) (*ssh.Session, error) {
+	client, err := Dial(cfg)
+	if err != nil {
+		return nil, err
+	}
+	session, err := client.NewSession()
+	if err != nil {
+		if cfg.Verbose {
+			fmt.Fprintln(cfg.ErrPipe, "Failed to create session: "+err.Error())
 		}
-	} else {
-		aSigners, err := sshagent.AgentClientDefault()
+	}
+	return session, err
+}
+
+// Dial Connects and authenticates, leaving the sessions to the caller
+func Dial(cfg Config) (*ssh.Client, error) {
+	signers := []ssh.Signer{}
+	userName := FillDefaultUsername(cfg.User)
+	host, port, errPipe := cfg.Host, cfg.Port, cfg.ErrPipe
+	idFiles := cfg.KeyFiles
+	if len(idFiles) == 0 {
+		// like ssh, fall back to the default identities after the agent
+		idFiles = defaultKeyFiles()
+	}
+	// with IdentitiesOnly the agent may still sign for the identity files
+	if len(cfg.KeyFiles) == 0 || cfg.IdentitiesOnly {
+		agentClient, aSigners, err := agentSigners(idFiles, cfg.IdentitiesOnly, cfg.AgentKeys)
 		if err != nil {
 			fmt.Fprintf(errPipe, "Error starting agent (%v)\n", err)
 		} else {
+			// authentication is over once Dial returns
+			defer agentClient.Close()
 			signers = append(signers, aSigners...)
 		}
 	}
+	signers = append(signers, loadKeyFiles(idFiles, errPipe)...)
+	signers = certSigners(signers, certFiles(idFiles, cfg.CertFiles), time.Now(), errPipe)
+	signers = uniqueSigners(signers)
 
-	auths := []ssh.AuthMethod{}
-	pubKeyAuth := ssh.PublicKeys(signers...)
-	auths = append(auths, pubKeyAuth)
-	// Add password authentication
-	if password {
-		password := pwauth.ClientAuthPrompt(userName, host)
-		passwordAuth := ssh.Password(password)
-		auths = append(auths, passwordAuth)
+	auths, err := authMethods(cfg.AuthMethods, signers, userName, host, cfg.Password, errPipe)
+	if err != nil {
+		fmt.Fprintln(errPipe, err.Error())
+		return nil, err
 	}
 	clientConfig := &ssh.ClientConfig{
 		User: userName,
 		Auth: auths,
 	}
-	if checkKnownHosts {
-		// Find home directory.
-		home, err := homedir.Dir()
-		if err != nil {
-			fmt.Fprintln(errPipe, "Failed to find home dir: "+err.Error())
-			return nil, err
+	target := fmt.Sprintf("%s:%d", host, port)
+	// -c alone means the hard failure it always did
+	strict := cfg.StrictHostKeys
+	if strict == "" {
+		strict = StrictNo
+		if cfg.CheckKnownHosts {
+			strict = StrictYes
+		}
+	}
+	err = checkStrictMode(strict)
+	if err != nil {
+		fmt.Fprintln(errPipe, err.Error())
+		return nil, err
+	}
+	var files []string
+	userFile := ""
+	if strict != StrictNo && len(cfg.HostKeyPins) == 0 {
+		files, err = knownHostsFiles(cfg.KnownHostsFiles)
+		if err == nil {
+			userFile, err = userKnownHostsFile()
 		}
-		clientConfig.HostKeyCallback, err = knownhosts.New(home + "/.ssh/known_hosts")
 		if err != nil {
-			fmt.Fprintln(errPipe, "Failed to known_hosts "+err.Error())
+			fmt.Fprintln(errPipe, "Failed to find known_hosts: "+err.Error())
 			return nil, err
 		}
+		clientConfig.HostKeyAlgorithms = hostKeyAlgorithms(files, target)
+	}
+	err = cryptoConfig(cfg, clientConfig)
+	if err != nil {
+		fmt.Fprintln(errPipe, err.Error())
+		return nil, err
+	}
+	if len(cfg.HostKeyPins) > 0 {
+		// pinned keys need no known_hosts at all
+		clientConfig.HostKeyCallback, err = pinnedHostKeyCallback(cfg.HostKeyPins, errPipe)
 	} else {
-		clientConfig.HostKeyCallback = ssh.InsecureIgnoreHostKey()
+		// handles @cert-authority and @revoked lines too
+		clientConfig.HostKeyCallback, err = newHostKeyCallback(strict, files, userFile, errPipe)
 	}
-	target := fmt.Sprintf("%s:%d", host, port)
-	client, err := ssh.Dial("tcp", target, clientConfig)
 	if err != nil {
-		if verbose {
-			fmt.Fprintln(errPipe, "Failed to dial: "+err.Error())
-		}
+		fmt.Fprintln(errPipe, "Failed to known_hosts "+err.Error())
 		return nil, err
 	}
-	session, err := client.NewSession()
+	client, err := dialTimeout(host, target, clientConfig, cfg.ConnectTimeout)
 	if err != nil {
-		if verbose {
-			fmt.Fprintln(errPipe, "Failed to create session: "+err.Error())
+		if _, ok := err.(*ConnectTimeoutError); ok {
+			fmt.Fprintln(errPipe, err.Error())
+		} else if cfg.Verbose {
+			fmt.Fprintln(errPipe, "Failed to dial: "+err.Error())
 		}
+		return nil, err
 	}
-	return session, err
+	monitor := cfg.Monitor
+	if monitor == nil {
+		monitor = NewMonitor()
+	}
+	monitor.start(client, host, cfg)
+	return client, nil
 }
@@ -0,0 +1,181 @@
+// Copyright © 2017 Ricardo Aravena <raravena@branch.io>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sshconn
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	homedir "github.com/mitchellh/go-homedir"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// SystemKnownHosts The system wide known hosts file
+const SystemKnownHosts = "/etc/ssh/ssh_known_hosts"
+
+// userKnownHostsFile Returns the path of the user's known_hosts file
+func userKnownHostsFile() (string, error) {
+	home, err := homedir.Dir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".ssh", "known_hosts"), nil
+}
+
+// knownHostsFiles Returns the user and system known_hosts files that exist,
+// followed by the extra files, which must exist.
+func knownHostsFiles(extra []string) ([]string, error) {
+	var files []string
+	userFile, err := userKnownHostsFile()
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range []string{userFile, SystemKnownHosts} {
+		if _, err := os.Stat(file); err == nil {
+			files = append(files, file)
+		}
+	}
+	for _, file := range extra {
+		if _, err := os.Stat(file); err != nil {
+			return nil, err
+		}
+		files = append(files, file)
+	}
+	return files, nil
+}
+
+// wildcardMatch Matches a known_hosts pattern where '*' and '?' are wildcards
+func wildcardMatch(pattern, s string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for i := len(s); i >= 0; i-- {
+				if wildcardMatch(pattern[1:], s[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if len(s) == 0 {
+				return false
+			}
+		default:
+			if len(s) == 0 || pattern[0] != s[0] {
+				return false
+			}
+		}
+		pattern, s = pattern[1:], s[1:]
+	}
+	return len(s) == 0
+}
+
+// hashMatches Checks a hashed known_hosts entry (|1|salt|hash) against host
+func hashMatches(entry, host string) bool {
+	parts := strings.Split(entry, "|")
+	if len(parts) != 4 || parts[1] != "1" {
+		return false
+	}
+	salt, err := base64.StdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return false
+	}
+	hash, err := base64.StdEncoding.DecodeString(parts[3])
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(sha1.New, salt)
+	mac.Write([]byte(host))
+	return hmac.Equal(mac.Sum(nil), hash)
+}
+
+// hostMatches Checks the host patterns of a known_hosts entry, honoring negation
+func hostMatches(patterns []string, host string) bool {
+	matched := false
+	for _, pattern := range patterns {
+		negated := strings.HasPrefix(pattern, "!")
+		pattern = strings.TrimPrefix(pattern, "!")
+		var ok bool
+		if strings.HasPrefix(pattern, "|") {
+			ok = hashMatches(pattern, host)
+		} else {
+			ok = wildcardMatch(pattern, host)
+		}
+		if ok && negated {
+			return false
+		}
+		matched = matched || ok
+	}
+	return matched
+}
+
+// keyAlgorithms Returns the host key algorithms that can carry a key type
+func keyAlgorithms(keyType string) []string {
+	if keyType == ssh.KeyAlgoRSA {
+		return []string{ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256}
+	}
+	return []string{keyType}
+}
+
+// hostKeyAlgorithms Orders the host key algorithms so the ones we already
+// know for the host come first: certificates when a @cert-authority line
+// covers it, then the types of its known keys, then everything else.
+// It returns nil when nothing is known about the host.
+func hostKeyAlgorithms(files []string, hostport string) []string {
+	host := knownhosts.Normalize(hostport)
+	var certAlgos, keyAlgos []string
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			continue
+		}
+		for _, line := range bytes.Split(data, []byte("\n")) {
+			marker, hosts, key, _, _, err := ssh.ParseKnownHosts(line)
+			if err != nil || !hostMatches(hosts, host) {
+				continue
+			}
+			switch marker {
+			case "cert-authority":
+				for _, algo := range ssh.SupportedAlgorithms().HostKeys {
+					if strings.Contains(algo, "-cert-") {
+						certAlgos = append(certAlgos, algo)
+					}
+				}
+			case "":
+				keyAlgos = append(keyAlgos, keyAlgorithms(key.Type())...)
+			}
+		}
+	}
+	if len(certAlgos) == 0 && len(keyAlgos) == 0 {
+		return nil
+	}
+	var algos []string
+	seen := map[string]bool{}
+	for _, list := range [][]string{certAlgos, keyAlgos, ssh.SupportedAlgorithms().HostKeys} {
+		for _, algo := range list {
+			if !seen[algo] {
+				seen[algo] = true
+				algos = append(algos, algo)
+			}
+		}
+	}
+	return algos
+}
@@ -0,0 +1,105 @@
+// Copyright © 2017 Ricardo Aravena <raravena@branch.io>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sshconn
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+func TestHostMatches(t *testing.T) {
+	tests := []struct {
+		name     string
+		patterns []string
+		host     string
+		expected bool
+	}{
+		{name: "Plain", patterns: []string{"host1"}, host: "host1", expected: true},
+		{name: "Other host", patterns: []string{"host1"}, host: "host2", expected: false},
+		{name: "Wildcard", patterns: []string{"*.example.com"}, host: "a.example.com", expected: true},
+		{name: "Question mark", patterns: []string{"host?"}, host: "host7", expected: true},
+		{name: "Negated", patterns: []string{"*.example.com", "!bad.example.com"}, host: "bad.example.com", expected: false},
+		{name: "Port", patterns: []string{"[host1]:2222"}, host: "[host1]:2222", expected: true},
+		{name: "Hashed", patterns: []string{knownhosts.HashHostname("host1")}, host: "host1", expected: true},
+		{name: "Hashed other host", patterns: []string{knownhosts.HashHostname("host1")}, host: "host2", expected: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			returned := hostMatches(tt.patterns, tt.host)
+			if returned != tt.expected {
+				t.Errorf("Value received: %v expected %v", returned, tt.expected)
+			}
+		})
+	}
+}
+
+func TestHostKeyAlgorithms(t *testing.T) {
+	dir, err := ioutil.TempDir("", "scpgo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaPub, err := ssh.NewPublicKey(&rsaKey.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca := newTestSigner(t).PublicKey()
+	file := filepath.Join(dir, "known_hosts")
+	ioutil.WriteFile(file, []byte(strings.Join([]string{
+		"# comment",
+		knownhosts.Line([]string{"rsahost"}, rsaPub),
+		"@cert-authority *.example.com " + strings.TrimSpace(string(ssh.MarshalAuthorizedKey(ca))),
+		"@revoked revokedhost " + strings.TrimSpace(string(ssh.MarshalAuthorizedKey(ca))),
+	}, "\n")), 0644)
+
+	tests := []struct {
+		name     string
+		hostport string
+		first    string // substring of the first algorithm
+	}{
+		{name: "Known RSA key", hostport: "rsahost:22", first: ssh.KeyAlgoRSASHA512},
+		{name: "Certificate authority", hostport: "a.example.com:22", first: "-cert-"},
+		{name: "Revoked only", hostport: "revokedhost:22"},
+		{name: "Unknown host", hostport: "unknown:22"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			returned := hostKeyAlgorithms([]string{file}, tt.hostport)
+			if tt.first == "" {
+				if returned != nil {
+					t.Errorf("Value received: %v expected nil", returned)
+				}
+				return
+			}
+			if len(returned) == 0 || !strings.Contains(returned[0], tt.first) {
+				t.Errorf("Value received: %v expected %v first", returned, tt.first)
+			}
+		})
+	}
+}
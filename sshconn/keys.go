@@ -0,0 +1,202 @@
+// Copyright © 2017 Ricardo Aravena <raravena@branch.io>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sshconn
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	homedir "github.com/mitchellh/go-homedir"
+	"github.com/raravena80/scpgo/pwauth"
+	"github.com/raravena80/scpgo/sshagent"
+	"github.com/raravena80/scpgo/sshkeys"
+	"golang.org/x/crypto/ssh"
+)
+
+// DefaultKeyFiles Identity files tried when none is given, in order
+var DefaultKeyFiles = []string{"id_ed25519", "id_ecdsa", "id_rsa"}
+
+// passphrasePrompt Asks for the passphrase of an encrypted key
+var passphrasePrompt = pwauth.KeyPassphrasePrompt
+
+var (
+	keyMu sync.Mutex
+	// decryptedKeys Encrypted keys already unlocked, by file, so a redial
+	// doesn't ask for the passphrase again
+	decryptedKeys = map[string]ssh.Signer{}
+)
+
+// lazySigner Holds an encrypted key whose public half is known, from the
+// key file itself or its .pub file, so the passphrase is only asked for if
+// the server accepts it.
+type lazySigner struct {
+	path string
+	pem  []byte
+	pub  ssh.PublicKey
+}
+
+func (ls *lazySigner) PublicKey() ssh.PublicKey {
+	return ls.pub
+}
+
+func (ls *lazySigner) load() (ssh.Signer, error) {
+	return decryptCached(ls.path, ls.pem)
+}
+
+func (ls *lazySigner) Sign(rand io.Reader, data []byte) (*ssh.Signature, error) {
+	signer, err := ls.load()
+	if err != nil {
+		return nil, err
+	}
+	return signer.Sign(rand, data)
+}
+
+func (ls *lazySigner) SignWithAlgorithm(rand io.Reader, data []byte, algorithm string) (*ssh.Signature, error) {
+	signer, err := ls.load()
+	if err != nil {
+		return nil, err
+	}
+	if as, ok := signer.(ssh.AlgorithmSigner); ok {
+		return as.SignWithAlgorithm(rand, data, algorithm)
+	}
+	return signer.Sign(rand, data)
+}
+
+// decryptCached Decrypts idFile the first time it is needed in the process
+func decryptCached(idFile string, pem []byte) (ssh.Signer, error) {
+	keyMu.Lock()
+	defer keyMu.Unlock()
+	if signer, ok := decryptedKeys[idFile]; ok {
+		return signer, nil
+	}
+	signer, err := decryptKey(idFile, pem)
+	if err != nil {
+		return nil, err
+	}
+	decryptedKeys[idFile] = signer
+	return signer, nil
+}
+
+// decryptKey Prompts for the passphrase of an encrypted key and parses it
+func decryptKey(idFile string, pem []byte) (ssh.Signer, error) {
+	pass, err := passphrasePrompt(idFile)
+	if err != nil {
+		return nil, err
+	}
+	signer, err := sshkeys.ParsePrivateKeyWithPassphrase(pem, []byte(pass))
+	if err != nil {
+		return nil, fmt.Errorf("Could not decrypt %s: %v", idFile, err)
+	}
+	return signer, nil
+}
+
+func loadKeyring(idFile string) (ssh.Signer, error) {
+	id, err := ioutil.ReadFile(idFile)
+	if err != nil {
+		return nil, err
+	}
+	signer, err := sshkeys.ParsePrivateKey(id)
+	if missing, ok := err.(*ssh.PassphraseMissingError); ok {
+		// the agent may hold the key already, only decrypt it to sign
+		if missing.PublicKey != nil {
+			return &lazySigner{path: idFile, pem: id, pub: missing.PublicKey}, nil
+		}
+		pubBytes, pubErr := ioutil.ReadFile(idFile + ".pub")
+		if pubErr == nil {
+			pub, _, _, _, pubErr := ssh.ParseAuthorizedKey(pubBytes)
+			if pubErr == nil {
+				return &lazySigner{path: idFile, pem: id, pub: pub}, nil
+			}
+		}
+		return decryptCached(idFile, id)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Could not parse %s: %v", idFile, err)
+	}
+	return signer, nil
+}
+
+// defaultKeyFiles Returns the default identity files that exist
+func defaultKeyFiles() []string {
+	home, err := homedir.Dir()
+	if err != nil {
+		return nil
+	}
+	var idFiles []string
+	for _, name := range DefaultKeyFiles {
+		idFile := filepath.Join(home, ".ssh", name)
+		if _, err := os.Stat(idFile); err == nil {
+			idFiles = append(idFiles, idFile)
+		}
+	}
+	return idFiles
+}
+
+// loadKeyFiles Loads the identity files in order, reporting the ones that fail
+func loadKeyFiles(idFiles []string, errPipe io.Writer) []ssh.Signer {
+	signers := []ssh.Signer{}
+	for _, idFile := range idFiles {
+		signer, err := loadKeyring(idFile)
+		if err != nil {
+			fmt.Fprintf(errPipe, "Error loading key file (%v)\n", err)
+			continue
+		}
+		signers = append(signers, signer)
+	}
+	return signers
+}
+
+// agentSigners Returns the agent keys to offer. With identitiesOnly only
+// those matching an identity file are kept, and agentKeys narrows them to
+// the given fingerprints, comments or public key files.
+func agentSigners(idFiles []string, identitiesOnly bool, agentKeys []string) (*sshagent.Client, []ssh.Signer, error) {
+	client, err := sshagent.DialDefault()
+	if err != nil {
+		return nil, nil, err
+	}
+	var filters []*sshagent.Filter
+	if identitiesOnly {
+		filters = append(filters, sshagent.NewFilter(idFiles))
+	}
+	if len(agentKeys) > 0 {
+		filters = append(filters, sshagent.NewFilter(agentKeys))
+	}
+	signers, err := client.Signers(filters...)
+	if err != nil {
+		client.Close()
+		return nil, nil, err
+	}
+	return client, signers, nil
+}
+
+// uniqueSigners Drops keys offered more than once, e.g. an identity file that
+// is also in the agent, since every offer counts against MaxAuthTries
+func uniqueSigners(signers []ssh.Signer) []ssh.Signer {
+	seen := map[string]bool{}
+	unique := []ssh.Signer{}
+	for _, signer := range signers {
+		blob := string(signer.PublicKey().Marshal())
+		if seen[blob] {
+			continue
+		}
+		seen[blob] = true
+		unique = append(unique, signer)
+	}
+	return unique
+}
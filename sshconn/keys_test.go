@@ -0,0 +1,224 @@
+// Copyright © 2017 Ricardo Aravena <raravena@branch.io>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sshconn
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/raravena80/scpgo/sshagent"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// writeTestKey Writes an ed25519 key, encrypted when passphrase isn't empty
+func writeTestKey(t *testing.T, dir, name, passphrase string, withPub bool) (string, ssh.PublicKey) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var block *pem.Block
+	if passphrase == "" {
+		block, err = ssh.MarshalPrivateKey(priv, "test")
+	} else {
+		block, err = ssh.MarshalPrivateKeyWithPassphrase(priv, "test", []byte(passphrase))
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, name)
+	ioutil.WriteFile(path, pem.EncodeToMemory(block), 0600)
+	sshPub, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if withPub {
+		ioutil.WriteFile(path+".pub", ssh.MarshalAuthorizedKey(sshPub), 0644)
+	}
+	return path, sshPub
+}
+
+func TestLoadKeyring(t *testing.T) {
+	dir, err := ioutil.TempDir("", "scpgo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	savePrompt := passphrasePrompt
+	defer func() { passphrasePrompt = savePrompt }()
+
+	tests := []struct {
+		name       string
+		passphrase string
+		answer     string
+		withPub    bool
+		prompts    int
+		fails      bool
+	}{
+		{name: "Plain key", prompts: 0},
+		{name: "Encrypted key", passphrase: "secret", answer: "secret", prompts: 1},
+		{name: "Encrypted key wrong passphrase", passphrase: "secret", answer: "wrong", prompts: 1, fails: true},
+		{name: "Encrypted key with public half", passphrase: "secret", answer: "secret", withPub: true, prompts: 1},
+	}
+
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			prompts := 0
+			passphrasePrompt = func(keyFile string) (string, error) {
+				prompts++
+				return tt.answer, nil
+			}
+			path, pub := writeTestKey(t, dir, string(rune('a'+i)), tt.passphrase, tt.withPub)
+			signer, err := loadKeyring(path)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if string(signer.PublicKey().Marshal()) != string(pub.Marshal()) {
+				t.Errorf("Public key does not match")
+			}
+			if prompts != 0 {
+				t.Errorf("Prompted before the key was used")
+			}
+			sig, err := signer.Sign(rand.Reader, []byte("data"))
+			if tt.fails {
+				if err == nil {
+					t.Errorf("Expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if err := pub.Verify([]byte("data"), sig); err != nil {
+				t.Errorf("Signature does not verify: %v", err)
+			}
+			// a redial loads the key again without asking
+			signer, err = loadKeyring(path)
+			if err == nil {
+				_, err = signer.Sign(rand.Reader, []byte("data"))
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if prompts != tt.prompts {
+				t.Errorf("Value received: %v expected %v", prompts, tt.prompts)
+			}
+		})
+	}
+}
+
+func TestLoadKeyFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "scpgo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	first, firstPub := writeTestKey(t, dir, "first", "", false)
+	second, secondPub := writeTestKey(t, dir, "second", "", false)
+	signers := loadKeyFiles([]string{first, filepath.Join(dir, "missing"), second}, ioutil.Discard)
+	if len(signers) != 2 {
+		t.Fatalf("Value received: %v expected %v", len(signers), 2)
+	}
+	if string(signers[0].PublicKey().Marshal()) != string(firstPub.Marshal()) ||
+		string(signers[1].PublicKey().Marshal()) != string(secondPub.Marshal()) {
+		t.Errorf("Keys not loaded in order")
+	}
+}
+
+func TestAgentSigners(t *testing.T) {
+	dir, err := ioutil.TempDir("", "scpgo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	keyring := sshagent.NewKeyring()
+	var idFiles []string
+	for _, name := range []string{"first", "second"} {
+		idFile, _ := writeTestKey(t, dir, name, "", true)
+		key, err := sshagent.LoadKey(idFile, 0, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		key.Comment = name
+		err = keyring.Add(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		idFiles = append(idFiles, idFile)
+	}
+	l, err := sshagent.Listen(filepath.Join(dir, "agent.sock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go sshagent.Serve(l, keyring)
+	socketSave := os.Getenv("SSH_AUTH_SOCK")
+	defer os.Setenv("SSH_AUTH_SOCK", socketSave)
+	os.Setenv("SSH_AUTH_SOCK", filepath.Join(dir, "agent.sock"))
+
+	tests := []struct {
+		name           string
+		idFiles        []string
+		identitiesOnly bool
+		agentKeys      []string
+		expected       int
+	}{
+		{name: "All agent keys", idFiles: idFiles[:1], expected: 2},
+		{name: "Identities only", idFiles: idFiles[:1], identitiesOnly: true, expected: 1},
+		{name: "By comment", agentKeys: []string{"second"}, expected: 1},
+		{name: "Identities only and comment", idFiles: idFiles[:1], identitiesOnly: true, agentKeys: []string{"second"}, expected: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, signers, err := agentSigners(tt.idFiles, tt.identitiesOnly, tt.agentKeys)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer client.Close()
+			if len(signers) != tt.expected {
+				t.Errorf("Value received: %v expected %v", len(signers), tt.expected)
+			}
+		})
+	}
+}
+
+func TestUniqueSigners(t *testing.T) {
+	dir, err := ioutil.TempDir("", "scpgo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	idFile, _ := writeTestKey(t, dir, "first", "", false)
+	signers := loadKeyFiles([]string{idFile, idFile}, ioutil.Discard)
+	key, err := sshagent.LoadKey(idFile, 0, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyring := agent.NewKeyring()
+	keyring.Add(key)
+	aSigners, err := keyring.Signers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	returned := len(uniqueSigners(append(aSigners, signers...)))
+	if returned != 1 {
+		t.Errorf("Value received: %v expected %v", returned, 1)
+	}
+}
@@ -0,0 +1,261 @@
+// Copyright © 2017 Ricardo Aravena <raravena@branch.io>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sshconn
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// DefaultServerAliveCountMax Unanswered keepalives before giving up, as in ssh
+const DefaultServerAliveCountMax = 3
+
+// ConnectTimeoutError The server did not answer within the connect timeout
+type ConnectTimeoutError struct {
+	Host    string
+	Timeout time.Duration
+}
+
+func (e *ConnectTimeoutError) Error() string {
+	return fmt.Sprintf("ssh: connect to host %s: timed out after %v", e.Host, e.Timeout)
+}
+
+// ServerAliveError The server stopped answering keepalives
+type ServerAliveError struct {
+	Host  string
+	Count int
+}
+
+func (e *ServerAliveError) Error() string {
+	return fmt.Sprintf("Timeout, server %s not responding to %d keepalives", e.Host, e.Count)
+}
+
+// StallError No data moved in either direction for too long
+type StallError struct {
+	Host    string
+	Timeout time.Duration
+}
+
+func (e *StallError) Error() string {
+	return fmt.Sprintf("Transfer from/to %s stalled: no data moved for %v", e.Host, e.Timeout)
+}
+
+// dialTimeout Dials like ssh.Dial, failing when the TCP connection or the
+// key exchange take longer than timeout. Authentication, which may wait on
+// the user, isn't limited.
+func dialTimeout(host, target string, clientConfig *ssh.ClientConfig, timeout time.Duration) (*ssh.Client, error) {
+	conn, err := net.DialTimeout("tcp", target, timeout)
+	if err != nil {
+		var ne net.Error
+		if timeout > 0 && errors.As(err, &ne) && ne.Timeout() {
+			return nil, &ConnectTimeoutError{Host: host, Timeout: timeout}
+		}
+		return nil, err
+	}
+	if timeout > 0 {
+		conn.SetDeadline(time.Now().Add(timeout))
+		config := *clientConfig
+		check := clientConfig.HostKeyCallback
+		// the server has answered once it shows its host key
+		config.HostKeyCallback = func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+			conn.SetDeadline(time.Time{})
+			return check(hostname, remote, key)
+		}
+		clientConfig = &config
+	}
+	c, chans, reqs, err := ssh.NewClientConn(conn, target, clientConfig)
+	if err != nil {
+		conn.Close()
+		var ne net.Error
+		if timeout > 0 && errors.As(err, &ne) && ne.Timeout() {
+			return nil, &ConnectTimeoutError{Host: host, Timeout: timeout}
+		}
+		return nil, err
+	}
+	return ssh.NewClient(c, chans, reqs), nil
+}
+
+// Monitor Closes a connection that stops answering keepalives or moving
+// data, and remembers why
+type Monitor struct {
+	mu     sync.Mutex
+	err    error
+	client *ssh.Client
+	last   int64
+	done   chan struct{}
+	once   sync.Once
+}
+
+// NewMonitor Returns a monitor to pass in Config
+func NewMonitor() *Monitor {
+	return &Monitor{done: make(chan struct{}), last: time.Now().UnixNano()}
+}
+
+// Err Returns why the monitor closed the connection, or nil
+func (m *Monitor) Err() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.err
+}
+
+// Stop Ends the watch once the transfer is over
+func (m *Monitor) Stop() {
+	m.once.Do(func() { close(m.done) })
+}
+
+// fail Records the first reason and closes the connection
+func (m *Monitor) fail(err error) {
+	m.mu.Lock()
+	if m.err == nil {
+		m.err = err
+	}
+	client := m.client
+	m.mu.Unlock()
+	m.Stop()
+	if client != nil {
+		client.Close()
+	}
+}
+
+// touch Records that data moved
+func (m *Monitor) touch() {
+	atomic.StoreInt64(&m.last, time.Now().UnixNano())
+}
+
+// Reader Counts reads from r as progress
+func (m *Monitor) Reader(r io.Reader) io.Reader {
+	return &monitorReader{r: r, m: m}
+}
+
+// Writer Counts writes to w as progress
+func (m *Monitor) Writer(w io.WriteCloser) io.WriteCloser {
+	return &monitorWriter{w: w, m: m}
+}
+
+type monitorReader struct {
+	r io.Reader
+	m *Monitor
+}
+
+func (mr *monitorReader) Read(p []byte) (int, error) {
+	n, err := mr.r.Read(p)
+	if n > 0 {
+		mr.m.touch()
+	}
+	return n, err
+}
+
+type monitorWriter struct {
+	w io.WriteCloser
+	m *Monitor
+}
+
+func (mw *monitorWriter) Write(p []byte) (int, error) {
+	n, err := mw.w.Write(p)
+	if n > 0 {
+		mw.m.touch()
+	}
+	return n, err
+}
+
+func (mw *monitorWriter) Close() error {
+	return mw.w.Close()
+}
+
+// start Begins watching client with the keepalive and stall settings
+func (m *Monitor) start(client *ssh.Client, host string, cfg Config) {
+	m.mu.Lock()
+	m.client = client
+	m.mu.Unlock()
+	m.touch()
+	go func() {
+		// a connection closed normally needs no more watching
+		client.Wait()
+		m.Stop()
+	}()
+	if cfg.ServerAliveInterval > 0 {
+		countMax := cfg.ServerAliveCountMax
+		if countMax <= 0 {
+			countMax = DefaultServerAliveCountMax
+		}
+		go m.keepAlive(client, host, cfg.ServerAliveInterval, countMax)
+	}
+	if cfg.StallTimeout > 0 {
+		go m.watchStall(host, cfg.StallTimeout)
+	}
+}
+
+// keepAlive Sends a global request every interval and gives up once
+// countMax of them are left unanswered
+func (m *Monitor) keepAlive(client *ssh.Client, host string, interval time.Duration, countMax int) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	replies := make(chan error, 1)
+	pending, missed := false, 0
+	for {
+		select {
+		case <-m.done:
+			return
+		case err := <-replies:
+			if err != nil {
+				return
+			}
+			pending, missed = false, 0
+		case <-ticker.C:
+			if pending {
+				missed++
+				if missed >= countMax {
+					m.fail(&ServerAliveError{Host: host, Count: missed})
+					return
+				}
+				continue
+			}
+			pending = true
+			go func() {
+				// any reply, even a refusal, means the server is alive
+				_, _, err := client.SendRequest("keepalive@openssh.com", true, nil)
+				replies <- err
+			}()
+		}
+	}
+}
+
+// watchStall Fails the connection when no data moved for timeout
+func (m *Monitor) watchStall(host string, timeout time.Duration) {
+	tick := timeout / 10
+	if tick > time.Second {
+		tick = time.Second
+	}
+	ticker := time.NewTicker(tick)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-m.done:
+			return
+		case <-ticker.C:
+			if time.Since(time.Unix(0, atomic.LoadInt64(&m.last))) >= timeout {
+				m.fail(&StallError{Host: host, Timeout: timeout})
+				return
+			}
+		}
+	}
+}
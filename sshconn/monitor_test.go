@@ -0,0 +1,126 @@
+// Copyright © 2017 Ricardo Aravena <raravena@branch.io>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sshconn
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// quietServer Serves one ssh connection, answering global requests only
+// when answer is set
+func quietServer(t *testing.T, answer bool) string {
+	config := &ssh.ServerConfig{NoClientAuth: true}
+	config.AddHostKey(newTestSigner(t))
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		defer l.Close()
+		c, err := l.Accept()
+		if err != nil {
+			return
+		}
+		_, _, reqs, err := ssh.NewServerConn(c, config)
+		if err != nil {
+			return
+		}
+		if answer {
+			ssh.DiscardRequests(reqs)
+		}
+		// otherwise leave keepalives unanswered, like a dropped link
+	}()
+	return l.Addr().String()
+}
+
+func testClient(t *testing.T, addr string) *ssh.Client {
+	client, err := dialTimeout("test", addr, &ssh.ClientConfig{User: "test", HostKeyCallback: ssh.InsecureIgnoreHostKey()}, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
+
+func TestDialTimeout(t *testing.T) {
+	// accepts the connection but never says hello
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		c, err := l.Accept()
+		if err == nil {
+			defer c.Close()
+			time.Sleep(2 * time.Second)
+		}
+	}()
+	config := &ssh.ClientConfig{User: "test", HostKeyCallback: ssh.InsecureIgnoreHostKey()}
+	_, err = dialTimeout("silent", l.Addr().String(), config, 200*time.Millisecond)
+	if _, ok := err.(*ConnectTimeoutError); !ok {
+		t.Errorf("Value received: %v expected %v", err, &ConnectTimeoutError{Host: "silent", Timeout: 200 * time.Millisecond})
+	}
+}
+
+func TestMonitor(t *testing.T) {
+	tests := []struct {
+		name     string
+		answer   bool
+		cfg      Config
+		feed     bool
+		expected error
+	}{
+		{name: "Keepalives answered", answer: true, cfg: Config{ServerAliveInterval: 20 * time.Millisecond, ServerAliveCountMax: 2}},
+		{name: "Keepalives unanswered", cfg: Config{ServerAliveInterval: 20 * time.Millisecond, ServerAliveCountMax: 2}, expected: &ServerAliveError{}},
+		{name: "Data moving", answer: true, cfg: Config{StallTimeout: 100 * time.Millisecond}, feed: true},
+		{name: "Stalled", answer: true, cfg: Config{StallTimeout: 100 * time.Millisecond}, expected: &StallError{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := testClient(t, quietServer(t, tt.answer))
+			defer client.Close()
+			m := NewMonitor()
+			defer m.Stop()
+			m.start(client, "test", tt.cfg)
+			r := m.Reader(bytes.NewReader(make([]byte, 100)))
+			for i := 0; i < 25; i++ {
+				if tt.feed {
+					r.Read(make([]byte, 1))
+				}
+				time.Sleep(20 * time.Millisecond)
+			}
+			returned := m.Err()
+			switch tt.expected.(type) {
+			case nil:
+				if returned != nil {
+					t.Errorf("Value received: %v expected %v", returned, nil)
+				}
+			case *ServerAliveError:
+				if _, ok := returned.(*ServerAliveError); !ok {
+					t.Errorf("Value received: %v expected %T", returned, tt.expected)
+				}
+			case *StallError:
+				if _, ok := returned.(*StallError); !ok {
+					t.Errorf("Value received: %v expected %T", returned, tt.expected)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,57 @@
+// Copyright © 2017 Ricardo Aravena <raravena@branch.io>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sshconn
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// normalizeFingerprint Strips the base64 padding some tools print
+func normalizeFingerprint(fingerprint string) (string, error) {
+	if !strings.HasPrefix(fingerprint, "SHA256:") {
+		return "", fmt.Errorf("Unsupported host key fingerprint '%s', expected SHA256:...", fingerprint)
+	}
+	return strings.TrimRight(fingerprint, "="), nil
+}
+
+// pinnedHostKeyCallback Accepts only host keys with one of the given
+// fingerprints; for a host certificate the certified key may match too.
+func pinnedHostKeyCallback(fingerprints []string, errPipe io.Writer) (ssh.HostKeyCallback, error) {
+	pins := map[string]bool{}
+	for _, fingerprint := range fingerprints {
+		pin, err := normalizeFingerprint(fingerprint)
+		if err != nil {
+			return nil, err
+		}
+		pins[pin] = true
+	}
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		presented := ssh.FingerprintSHA256(key)
+		if pins[presented] {
+			return nil
+		}
+		if cert, ok := key.(*ssh.Certificate); ok && pins[ssh.FingerprintSHA256(cert.Key)] {
+			return nil
+		}
+		fmt.Fprintf(errPipe, "Host key for %s does not match the pinned fingerprints\n", hostname)
+		fmt.Fprintf(errPipe, "Presented %s key: %s\n", key.Type(), presented)
+		return fmt.Errorf("host key %s for %s is not pinned", presented, hostname)
+	}, nil
+}
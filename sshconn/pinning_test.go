@@ -0,0 +1,65 @@
+// Copyright © 2017 Ricardo Aravena <raravena@branch.io>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sshconn
+
+import (
+	"bytes"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestPinnedHostKeyCallback(t *testing.T) {
+	addr := &net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 22}
+	key := newTestSigner(t).PublicKey()
+	other := newTestSigner(t).PublicKey()
+	now := time.Now()
+	hostCert := newTestCert(t, newTestSigner(t), key, ssh.HostCert, now.Add(-time.Hour), now.Add(time.Hour))
+
+	tests := []struct {
+		name     string
+		pins     []string
+		key      ssh.PublicKey
+		accepted bool
+	}{
+		{name: "Pinned key", pins: []string{ssh.FingerprintSHA256(other), ssh.FingerprintSHA256(key)}, key: key, accepted: true},
+		{name: "Padded fingerprint", pins: []string{ssh.FingerprintSHA256(key) + "="}, key: key, accepted: true},
+		{name: "Certified key is pinned", pins: []string{ssh.FingerprintSHA256(key)}, key: hostCert, accepted: true},
+		{name: "Other key", pins: []string{ssh.FingerprintSHA256(key)}, key: other, accepted: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			cb, err := pinnedHostKeyCallback(tt.pins, &out)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = cb("host1:22", addr, tt.key)
+			if (err == nil) != tt.accepted {
+				t.Errorf("Value received: %v expected accepted %v", err, tt.accepted)
+			}
+			if !tt.accepted && !strings.Contains(out.String(), ssh.FingerprintSHA256(tt.key)) {
+				t.Errorf("Presented fingerprint not shown in %q", out.String())
+			}
+		})
+	}
+	if _, err := pinnedHostKeyCallback([]string{"MD5:aa:bb"}, &bytes.Buffer{}); err == nil {
+		t.Errorf("Expected an error for a non SHA256 fingerprint")
+	}
+}
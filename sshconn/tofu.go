@@ -0,0 +1,147 @@
+// Copyright © 2017 Ricardo Aravena <raravena@branch.io>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sshconn
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/raravena80/scpgo/pwauth"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// StrictHostKeyChecking modes, as in ssh_config
+const (
+	StrictYes       = "yes"
+	StrictAcceptNew = "accept-new"
+	StrictAsk       = "ask"
+	StrictNo        = "no"
+)
+
+// confirmPrompt Asks whether to trust an unknown host key
+var confirmPrompt = pwauth.ConfirmPrompt
+
+// HostKeyChangedError Returned when a host presents a key other than the known one
+type HostKeyChangedError struct {
+	Host        string
+	Fingerprint string
+	Known       []knownhosts.KnownKey
+}
+
+func (e *HostKeyChangedError) Error() string {
+	return fmt.Sprintf("host key for %s has changed (now %s), refusing to connect", e.Host, e.Fingerprint)
+}
+
+// HostKeyUnknownError Returned when a host isn't known and can't be added
+type HostKeyUnknownError struct {
+	Host        string
+	Fingerprint string
+}
+
+func (e *HostKeyUnknownError) Error() string {
+	return fmt.Sprintf("no %s host key is known for %s", e.Fingerprint, e.Host)
+}
+
+// checkStrictMode Validates a StrictHostKeyChecking mode
+func checkStrictMode(mode string) error {
+	switch mode {
+	case StrictYes, StrictAcceptNew, StrictAsk, StrictNo:
+		return nil
+	}
+	return fmt.Errorf("Unknown StrictHostKeyChecking mode '%s' (use %s, %s, %s or %s)", mode, StrictYes, StrictAcceptNew, StrictAsk, StrictNo)
+}
+
+// changedKeyWarning Prints the same banner as ssh when a host key changed
+func changedKeyWarning(errPipe io.Writer, e *HostKeyChangedError) {
+	fmt.Fprintln(errPipe, "@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@")
+	fmt.Fprintln(errPipe, "@    WARNING: REMOTE HOST IDENTIFICATION HAS CHANGED!     @")
+	fmt.Fprintln(errPipe, "@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@")
+	fmt.Fprintln(errPipe, "IT IS POSSIBLE THAT SOMEONE IS DOING SOMETHING NASTY!")
+	fmt.Fprintf(errPipe, "The host key for %s is now %s.\n", e.Host, e.Fingerprint)
+	for _, known := range e.Known {
+		fmt.Fprintf(errPipe, "Known key: %s in %s:%d\n", ssh.FingerprintSHA256(known.Key), known.Filename, known.Line)
+	}
+	fmt.Fprintln(errPipe, "Remove the offending entry from known_hosts to get rid of this message.")
+}
+
+// addKnownHost Appends a hashed entry for host to a known_hosts file
+func addKnownHost(file, host string, key ssh.PublicKey) error {
+	err := os.MkdirAll(filepath.Dir(file), 0700)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(file, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	line := knownhosts.Line([]string{knownhosts.HashHostname(knownhosts.Normalize(host))}, key)
+	_, err = f.WriteString(line + "\n")
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// newHostKeyCallback Builds the host key check for a StrictHostKeyChecking
+// mode. New keys are recorded in userFile for accept-new, and for ask once
+// confirmed; a changed key always fails.
+func newHostKeyCallback(mode string, files []string, userFile string, errPipe io.Writer) (ssh.HostKeyCallback, error) {
+	if mode == StrictNo {
+		return ssh.InsecureIgnoreHostKey(), nil
+	}
+	known, err := knownhosts.New(files...)
+	if err != nil {
+		return nil, err
+	}
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		err := known(hostname, remote, key)
+		keyErr, ok := err.(*knownhosts.KeyError)
+		if !ok {
+			return err
+		}
+		fingerprint := ssh.FingerprintSHA256(key)
+		if len(keyErr.Want) > 0 {
+			changed := &HostKeyChangedError{Host: hostname, Fingerprint: fingerprint, Known: keyErr.Want}
+			changedKeyWarning(errPipe, changed)
+			return changed
+		}
+		switch mode {
+		case StrictAsk:
+			question := fmt.Sprintf("The authenticity of host '%s' can't be established.\n%s key fingerprint is %s.\nAre you sure you want to continue connecting (yes/no)? ", hostname, key.Type(), fingerprint)
+			ok, err := confirmPrompt(question)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				return &HostKeyUnknownError{Host: hostname, Fingerprint: fingerprint}
+			}
+		case StrictAcceptNew:
+		default:
+			return &HostKeyUnknownError{Host: hostname, Fingerprint: fingerprint}
+		}
+		err = addKnownHost(userFile, hostname, key)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(errPipe, "Warning: Permanently added '%s' (%s) to the list of known hosts.\n", strings.TrimSuffix(hostname, ":22"), key.Type())
+		return nil
+	}, nil
+}
@@ -0,0 +1,105 @@
+// Copyright © 2017 Ricardo Aravena <raravena@branch.io>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sshconn
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestNewHostKeyCallback(t *testing.T) {
+	saveConfirm := confirmPrompt
+	defer func() { confirmPrompt = saveConfirm }()
+	addr := &net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 22}
+	key := newTestSigner(t).PublicKey()
+	other := newTestSigner(t).PublicKey()
+
+	tests := []struct {
+		name    string
+		mode    string
+		answer  bool
+		added   bool
+		failure string
+	}{
+		{name: "Yes rejects unknown host", mode: StrictYes, failure: "no "},
+		{name: "Accept new adds the key", mode: StrictAcceptNew, added: true},
+		{name: "Ask confirmed", mode: StrictAsk, answer: true, added: true},
+		{name: "Ask refused", mode: StrictAsk, answer: false, failure: "no "},
+		{name: "No accepts anything", mode: StrictNo},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "scpgo")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+			userFile := filepath.Join(dir, ".ssh", "known_hosts")
+			asked := ""
+			confirmPrompt = func(question string) (bool, error) {
+				asked = question
+				return tt.answer, nil
+			}
+			cb, err := newHostKeyCallback(tt.mode, nil, userFile, ioutil.Discard)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = cb("host1:22", addr, key)
+			if tt.failure != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.failure) {
+					t.Errorf("Value received: %v expected %v", err, tt.failure)
+				}
+			} else if err != nil {
+				t.Errorf("Unexpected error: %v", err)
+			}
+			if tt.mode == StrictAsk && !strings.Contains(asked, "SHA256:") {
+				t.Errorf("Fingerprint not shown in %q", asked)
+			}
+			_, statErr := os.Stat(userFile)
+			if (statErr == nil) != tt.added {
+				t.Fatalf("Value received: %v expected %v", statErr == nil, tt.added)
+			}
+			if !tt.added {
+				return
+			}
+			content, _ := ioutil.ReadFile(userFile)
+			if !strings.HasPrefix(string(content), "|1|") {
+				t.Errorf("Entry is not hashed: %q", content)
+			}
+			// the recorded key is now trusted, and any other key is refused loudly
+			var banner bytes.Buffer
+			cb, err = newHostKeyCallback(StrictYes, []string{userFile}, userFile, &banner)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := cb("host1:22", addr, key); err != nil {
+				t.Errorf("Unexpected error: %v", err)
+			}
+			err = cb("host1:22", addr, other)
+			if _, ok := err.(*HostKeyChangedError); !ok {
+				t.Errorf("Value received: %v expected a HostKeyChangedError", err)
+			}
+			if !strings.Contains(banner.String(), "REMOTE HOST IDENTIFICATION HAS CHANGED") {
+				t.Errorf("No warning banner in %q", banner.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,78 @@
+// Copyright © 2017 Ricardo Aravena <raravena@branch.io>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sshkeys
+
+import (
+	"bytes"
+	"encoding/pem"
+	"errors"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// ParseRawPrivateKey Parses an unencrypted private key. An encrypted one
+// gives a *ssh.PassphraseMissingError, with the public key when the format
+// stores it in the clear.
+func ParseRawPrivateKey(data []byte) (interface{}, error) {
+	return parse(data, nil)
+}
+
+// ParseRawPrivateKeyWithPassphrase Parses a private key encrypted with
+// passphrase. A wrong passphrase gives x509.IncorrectPasswordError.
+func ParseRawPrivateKeyWithPassphrase(data, passphrase []byte) (interface{}, error) {
+	if passphrase == nil {
+		passphrase = []byte{}
+	}
+	return parse(data, passphrase)
+}
+
+// ParsePrivateKey Like ParseRawPrivateKey, returning a signer
+func ParsePrivateKey(data []byte) (ssh.Signer, error) {
+	return signer(ParseRawPrivateKey(data))
+}
+
+// ParsePrivateKeyWithPassphrase Like ParseRawPrivateKeyWithPassphrase,
+// returning a signer
+func ParsePrivateKeyWithPassphrase(data, passphrase []byte) (ssh.Signer, error) {
+	return signer(ParseRawPrivateKeyWithPassphrase(data, passphrase))
+}
+
+func signer(key interface{}, err error) (ssh.Signer, error) {
+	if err != nil {
+		return nil, err
+	}
+	return ssh.NewSignerFromKey(key)
+}
+
+// parse Decodes data, with a nil passphrase meaning none was given
+func parse(data, passphrase []byte) (interface{}, error) {
+	if isPPK(data) {
+		return parsePPK(data, passphrase)
+	}
+	if bytes.Contains(data, []byte("---- BEGIN SSH2 ENCRYPTED PRIVATE KEY ----")) {
+		return nil, errors.New("unsupported key format: SSH.COM keys must be converted with ssh-keygen -i or puttygen")
+	}
+	block, _ := pem.Decode(data)
+	if block != nil && block.Type == "ENCRYPTED PRIVATE KEY" {
+		if passphrase == nil {
+			return nil, &ssh.PassphraseMissingError{}
+		}
+		return decryptPKCS8(block.Bytes, passphrase)
+	}
+	if passphrase == nil {
+		return ssh.ParseRawPrivateKey(data)
+	}
+	return ssh.ParseRawPrivateKeyWithPassphrase(data, passphrase)
+}
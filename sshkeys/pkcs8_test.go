@@ -0,0 +1,74 @@
+// Copyright © 2017 Ricardo Aravena <raravena@branch.io>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sshkeys
+
+import (
+	"bytes"
+	"crypto/x509"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestParsePKCS8(t *testing.T) {
+	plain, err := ioutil.ReadFile(filepath.Join("testdata", "ecdsa-pkcs8.pem"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecdsaSigner, err := ParsePrivateKey(plain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name       string
+		file       string
+		passphrase []byte
+		pub        ssh.PublicKey
+		expected   error
+	}{
+		{name: "Ed25519 AES-256 PBKDF2-SHA256", file: "ed25519-pkcs8-encrypted.pem", passphrase: []byte("secret"), pub: readPublicKey(t, "ed25519.pub")},
+		{name: "ECDSA 3DES", file: "ecdsa-pkcs8-encrypted.pem", passphrase: []byte("secret"), pub: ecdsaSigner.PublicKey()},
+		{name: "Wrong passphrase", file: "ecdsa-pkcs8-encrypted.pem", passphrase: []byte("wrong"), expected: x509.IncorrectPasswordError},
+		{name: "No passphrase", file: "ecdsa-pkcs8-encrypted.pem", expected: &ssh.PassphraseMissingError{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := ioutil.ReadFile(filepath.Join("testdata", tt.file))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var signer ssh.Signer
+			if tt.passphrase == nil {
+				signer, err = ParsePrivateKey(data)
+			} else {
+				signer, err = ParsePrivateKeyWithPassphrase(data, tt.passphrase)
+			}
+			if tt.expected != nil {
+				if err == nil || err.Error() != tt.expected.Error() {
+					t.Errorf("Value received: %v expected %v", err, tt.expected)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(signer.PublicKey().Marshal(), tt.pub.Marshal()) {
+				t.Errorf("Value received: %v expected %v", ssh.FingerprintSHA256(signer.PublicKey()), ssh.FingerprintSHA256(tt.pub))
+			}
+		})
+	}
+}
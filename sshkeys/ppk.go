@@ -0,0 +1,369 @@
+// Copyright © 2017 Ricardo Aravena <raravena@branch.io>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sshkeys
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/dsa"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/ssh"
+)
+
+// ppkMagic Starts the first line of every PuTTY key file
+const ppkMagic = "PuTTY-User-Key-File-"
+
+// ppkFile The fields of a PuTTY key file
+type ppkFile struct {
+	version    int
+	algorithm  string
+	encryption string
+	comment    string
+	public     []byte
+	private    []byte
+	mac        []byte
+	kdf        string
+	memory     uint32
+	passes     uint32
+	threads    uint8
+	salt       []byte
+}
+
+// isPPK Reports whether data looks like a PuTTY key file
+func isPPK(data []byte) bool {
+	return bytes.HasPrefix(bytes.TrimLeft(data, "\r\n\t "), []byte(ppkMagic))
+}
+
+// parsePPKFile Splits a PuTTY key file into its fields
+func parsePPKFile(data []byte) (*ppkFile, error) {
+	f := &ppkFile{}
+	headers := map[string]string{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if line == "" {
+			continue
+		}
+		parts := strings.SplitN(line, ": ", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("ppk: malformed line %q", line)
+		}
+		key, value := parts[0], parts[1]
+		if strings.HasPrefix(key, ppkMagic) {
+			version, err := strconv.Atoi(strings.TrimPrefix(key, ppkMagic))
+			if err != nil {
+				return nil, fmt.Errorf("ppk: malformed header %q", line)
+			}
+			f.version, f.algorithm = version, value
+			continue
+		}
+		if key == "Public-Lines" || key == "Private-Lines" {
+			blob, err := readPPKLines(scanner, value)
+			if err != nil {
+				return nil, err
+			}
+			if key == "Public-Lines" {
+				f.public = blob
+			} else {
+				f.private = blob
+			}
+			continue
+		}
+		headers[key] = value
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if f.version != 2 && f.version != 3 {
+		return nil, fmt.Errorf("ppk: unsupported PuTTY key file version %d, convert it with puttygen", f.version)
+	}
+	f.encryption, f.comment = headers["Encryption"], headers["Comment"]
+	if f.public == nil || f.private == nil || headers["Private-MAC"] == "" {
+		return nil, errors.New("ppk: incomplete PuTTY key file")
+	}
+	var err error
+	f.mac, err = hex.DecodeString(headers["Private-MAC"])
+	if err != nil {
+		return nil, fmt.Errorf("ppk: malformed Private-MAC: %v", err)
+	}
+	switch f.encryption {
+	case "none":
+	case "aes256-cbc":
+		if len(f.private)%aes.BlockSize != 0 {
+			return nil, errors.New("ppk: private blob is not a whole number of blocks")
+		}
+	default:
+		return nil, fmt.Errorf("ppk: unsupported encryption %q", f.encryption)
+	}
+	if f.version == 3 && f.encryption != "none" {
+		f.kdf = headers["Key-Derivation"]
+		memory, err1 := strconv.ParseUint(headers["Argon2-Memory"], 10, 32)
+		passes, err2 := strconv.ParseUint(headers["Argon2-Passes"], 10, 32)
+		threads, err3 := strconv.ParseUint(headers["Argon2-Parallelism"], 10, 8)
+		salt, err4 := hex.DecodeString(headers["Argon2-Salt"])
+		for _, err := range []error{err1, err2, err3, err4} {
+			if err != nil {
+				return nil, fmt.Errorf("ppk: malformed Argon2 parameters: %v", err)
+			}
+		}
+		f.memory, f.passes, f.threads, f.salt = uint32(memory), uint32(passes), uint8(threads), salt
+	}
+	return f, nil
+}
+
+// readPPKLines Reads count lines of base64
+func readPPKLines(scanner *bufio.Scanner, count string) ([]byte, error) {
+	n, err := strconv.Atoi(count)
+	if err != nil || n < 0 {
+		return nil, fmt.Errorf("ppk: malformed line count %q", count)
+	}
+	var b64 strings.Builder
+	for i := 0; i < n; i++ {
+		if !scanner.Scan() {
+			return nil, errors.New("ppk: truncated PuTTY key file")
+		}
+		b64.WriteString(strings.TrimSpace(scanner.Text()))
+	}
+	return base64.StdEncoding.DecodeString(b64.String())
+}
+
+// keys Derives the cipher key, IV and MAC key from the passphrase
+func (f *ppkFile) keys(passphrase []byte) (key, iv, macKey []byte, err error) {
+	if f.version == 2 {
+		h := sha1.New()
+		h.Write([]byte("putty-private-key-file-mac-key"))
+		if f.encryption != "none" {
+			h.Write(passphrase)
+		}
+		macKey = h.Sum(nil)
+		if f.encryption != "none" {
+			for seq := uint32(0); seq < 2; seq++ {
+				h := sha1.New()
+				binary.Write(h, binary.BigEndian, seq)
+				h.Write(passphrase)
+				key = h.Sum(key)
+			}
+			key, iv = key[:32], make([]byte, aes.BlockSize)
+		}
+		return key, iv, macKey, nil
+	}
+	if f.encryption == "none" {
+		return nil, nil, []byte{}, nil
+	}
+	var derived []byte
+	switch f.kdf {
+	case "Argon2id":
+		derived = argon2.IDKey(passphrase, f.salt, f.passes, f.memory, f.threads, 80)
+	case "Argon2i":
+		derived = argon2.Key(passphrase, f.salt, f.passes, f.memory, f.threads, 80)
+	default:
+		return nil, nil, nil, fmt.Errorf("ppk: unsupported key derivation %q, re-save the key with Argon2id", f.kdf)
+	}
+	return derived[:32], derived[32:48], derived[48:], nil
+}
+
+// sum Computes the MAC over the decrypted private blob
+func (f *ppkFile) sum(macKey, private []byte) []byte {
+	var mac hash.Hash
+	if f.version == 2 {
+		mac = hmac.New(sha1.New, macKey)
+	} else {
+		mac = hmac.New(sha256.New, macKey)
+	}
+	for _, field := range [][]byte{[]byte(f.algorithm), []byte(f.encryption), []byte(f.comment), f.public, private} {
+		binary.Write(mac, binary.BigEndian, uint32(len(field)))
+		mac.Write(field)
+	}
+	return mac.Sum(nil)
+}
+
+// checkMAC Verifies the MAC, which is how a wrong passphrase shows up
+func (f *ppkFile) checkMAC(macKey, private []byte) error {
+	if subtle.ConstantTimeCompare(f.sum(macKey, private), f.mac) != 1 {
+		if f.encryption != "none" {
+			return x509.IncorrectPasswordError
+		}
+		return errors.New("ppk: MAC mismatch, the key file is corrupted")
+	}
+	return nil
+}
+
+// decrypt Returns the private blob of the key
+func (f *ppkFile) decrypt(passphrase []byte) ([]byte, error) {
+	key, iv, macKey, err := f.keys(passphrase)
+	if err != nil {
+		return nil, err
+	}
+	private := f.private
+	if f.encryption != "none" {
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		private = make([]byte, len(f.private))
+		cipher.NewCBCDecrypter(block, iv).CryptBlocks(private, f.private)
+	}
+	return private, f.checkMAC(macKey, private)
+}
+
+// ppkReader Reads the SSH wire encoding of the key blobs
+type ppkReader struct {
+	data []byte
+	err  error
+}
+
+func (r *ppkReader) bytes() []byte {
+	if r.err != nil {
+		return nil
+	}
+	if len(r.data) < 4 {
+		r.err = errors.New("ppk: truncated key blob")
+		return nil
+	}
+	n := binary.BigEndian.Uint32(r.data)
+	if uint64(len(r.data)-4) < uint64(n) {
+		r.err = errors.New("ppk: truncated key blob")
+		return nil
+	}
+	b := r.data[4 : 4+n]
+	r.data = r.data[4+n:]
+	return b
+}
+
+func (r *ppkReader) mpint() *big.Int {
+	return new(big.Int).SetBytes(r.bytes())
+}
+
+// privateKey Builds the crypto key from the public and private blobs
+func (f *ppkFile) privateKey(private []byte) (interface{}, error) {
+	pub := &ppkReader{data: f.public}
+	priv := &ppkReader{data: private}
+	if algorithm := string(pub.bytes()); algorithm != f.algorithm {
+		return nil, fmt.Errorf("ppk: public key is %q, header says %q", algorithm, f.algorithm)
+	}
+	var key interface{}
+	switch f.algorithm {
+	case ssh.KeyAlgoRSA:
+		e, n := pub.mpint(), pub.mpint()
+		d, p, q := priv.mpint(), priv.mpint(), priv.mpint()
+		if !e.IsInt64() {
+			return nil, errors.New("ppk: RSA exponent too large")
+		}
+		k := &rsa.PrivateKey{
+			PublicKey: rsa.PublicKey{N: n, E: int(e.Int64())},
+			D:         d,
+			Primes:    []*big.Int{p, q},
+		}
+		if pub.err == nil && priv.err == nil {
+			if err := k.Validate(); err != nil {
+				return nil, fmt.Errorf("ppk: invalid RSA key: %v", err)
+			}
+			k.Precompute()
+		}
+		key = k
+	case ssh.KeyAlgoDSA:
+		k := &dsa.PrivateKey{}
+		k.P, k.Q, k.G, k.Y = pub.mpint(), pub.mpint(), pub.mpint(), pub.mpint()
+		k.X = priv.mpint()
+		key = k
+	case ssh.KeyAlgoECDSA256, ssh.KeyAlgoECDSA384, ssh.KeyAlgoECDSA521:
+		curves := map[string]elliptic.Curve{
+			"nistp256": elliptic.P256(),
+			"nistp384": elliptic.P384(),
+			"nistp521": elliptic.P521(),
+		}
+		curve := curves[string(pub.bytes())]
+		point := pub.bytes()
+		d := priv.mpint()
+		if curve == nil {
+			return nil, fmt.Errorf("ppk: curve does not match %q", f.algorithm)
+		}
+		if pub.err == nil && priv.err == nil {
+			x, y := elliptic.Unmarshal(curve, point)
+			if x == nil {
+				return nil, errors.New("ppk: invalid ECDSA public key")
+			}
+			key = &ecdsa.PrivateKey{PublicKey: ecdsa.PublicKey{Curve: curve, X: x, Y: y}, D: d}
+		}
+	case ssh.KeyAlgoED25519:
+		point := pub.bytes()
+		// PuTTY stores the seed as a little-endian integer, dropping
+		// trailing zero bytes
+		seed := priv.bytes()
+		if pub.err == nil && priv.err == nil {
+			if len(point) != ed25519.PublicKeySize || len(seed) > ed25519.SeedSize {
+				return nil, errors.New("ppk: invalid Ed25519 key")
+			}
+			full := make([]byte, ed25519.SeedSize)
+			copy(full, seed)
+			k := ed25519.NewKeyFromSeed(full)
+			if !bytes.Equal(k.Public().(ed25519.PublicKey), point) {
+				return nil, errors.New("ppk: Ed25519 private key does not match the public key")
+			}
+			key = k
+		}
+	default:
+		return nil, fmt.Errorf("ppk: unsupported key type %q", f.algorithm)
+	}
+	if pub.err != nil {
+		return nil, pub.err
+	}
+	if priv.err != nil {
+		return nil, priv.err
+	}
+	return key, nil
+}
+
+// parsePPK Decodes a PuTTY key file. An encrypted file without a passphrase
+// gives a *ssh.PassphraseMissingError holding the public key.
+func parsePPK(data, passphrase []byte) (interface{}, error) {
+	f, err := parsePPKFile(data)
+	if err != nil {
+		return nil, err
+	}
+	if f.encryption != "none" && passphrase == nil {
+		pub, err := ssh.ParsePublicKey(f.public)
+		if err != nil {
+			return nil, fmt.Errorf("ppk: invalid public key: %v", err)
+		}
+		return nil, &ssh.PassphraseMissingError{PublicKey: pub}
+	}
+	private, err := f.decrypt(passphrase)
+	if err != nil {
+		return nil, err
+	}
+	return f.privateKey(private)
+}
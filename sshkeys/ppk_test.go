@@ -0,0 +1,143 @@
+// Copyright © 2017 Ricardo Aravena <raravena@branch.io>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sshkeys
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// readPublicKey Reads a public key from testdata
+func readPublicKey(t *testing.T, name string) ssh.PublicKey {
+	b, err := ioutil.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub, _, _, _, err := ssh.ParseAuthorizedKey(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pub
+}
+
+// encryptPPKv3 Re-saves a PuTTY key file encrypted with Argon2id
+func encryptPPKv3(t *testing.T, data []byte, passphrase string) []byte {
+	f, err := parsePPKFile(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.encryption, f.kdf = "aes256-cbc", "Argon2id"
+	f.memory, f.passes, f.threads, f.salt = 64, 1, 1, []byte("0123456789abcdef")
+	private := append([]byte{}, f.private...)
+	for len(private)%aes.BlockSize != 0 {
+		private = append(private, 0)
+	}
+	key, iv, macKey, err := f.keys([]byte(passphrase))
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encrypted := make([]byte, len(private))
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(encrypted, private)
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "PuTTY-User-Key-File-3: %s\nEncryption: %s\nComment: %s\n", f.algorithm, f.encryption, f.comment)
+	fmt.Fprintf(&b, "Public-Lines: 1\n%s\n", base64.StdEncoding.EncodeToString(f.public))
+	fmt.Fprintf(&b, "Key-Derivation: Argon2id\nArgon2-Memory: %d\nArgon2-Passes: %d\nArgon2-Parallelism: %d\nArgon2-Salt: %s\n",
+		f.memory, f.passes, f.threads, hex.EncodeToString(f.salt))
+	fmt.Fprintf(&b, "Private-Lines: 1\n%s\n", base64.StdEncoding.EncodeToString(encrypted))
+	fmt.Fprintf(&b, "Private-MAC: %s\n", hex.EncodeToString(f.sum(macKey, private)))
+	return b.Bytes()
+}
+
+func TestParsePPK(t *testing.T) {
+	read := func(name string) []byte {
+		b, err := ioutil.ReadFile(filepath.Join("testdata", name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+	edV3 := read("ed25519-v3.ppk")
+	tests := []struct {
+		name       string
+		data       []byte
+		passphrase []byte
+		pub        string
+		expected   string
+	}{
+		{name: "RSA v2", data: read("rsa-v2.ppk"), pub: "rsa.pub"},
+		{name: "RSA v2 encrypted", data: read("rsa-v2-encrypted.ppk"), passphrase: []byte("secret"), pub: "rsa.pub"},
+		{name: "RSA v2 no passphrase", data: read("rsa-v2-encrypted.ppk"), pub: "rsa.pub", expected: "passphrase"},
+		{name: "RSA v2 wrong passphrase", data: read("rsa-v2-encrypted.ppk"), passphrase: []byte("wrong"), expected: x509.IncorrectPasswordError.Error()},
+		{name: "Ed25519 v3", data: edV3, pub: "ed25519.pub"},
+		{name: "Ed25519 v3 Argon2id", data: encryptPPKv3(t, edV3, "secret"), passphrase: []byte("secret"), pub: "ed25519.pub"},
+		{name: "Ed25519 v3 Argon2id wrong passphrase", data: encryptPPKv3(t, edV3, "secret"), passphrase: []byte("wrong"), expected: x509.IncorrectPasswordError.Error()},
+		{name: "Corrupted", data: bytes.Replace(edV3, []byte("Private-MAC: "), []byte("Private-MAC: 00"), 1), expected: "corrupted"},
+		{name: "Version 1", data: []byte("PuTTY-User-Key-File-1: ssh-rsa\n"), expected: "version 1"},
+		{name: "Argon2d", data: bytes.Replace(encryptPPKv3(t, edV3, "secret"), []byte("Argon2id"), []byte("Argon2d"), 1), passphrase: []byte("secret"), expected: "Argon2d"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var signer ssh.Signer
+			var err error
+			if tt.passphrase == nil {
+				signer, err = ParsePrivateKey(tt.data)
+			} else {
+				signer, err = ParsePrivateKeyWithPassphrase(tt.data, tt.passphrase)
+			}
+			if tt.expected != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.expected) {
+					t.Fatalf("Value received: %v expected %v", err, tt.expected)
+				}
+				// the public key is readable without the passphrase
+				if missing, ok := err.(*ssh.PassphraseMissingError); ok && tt.pub != "" {
+					expected := readPublicKey(t, tt.pub)
+					if !bytes.Equal(missing.PublicKey.Marshal(), expected.Marshal()) {
+						t.Errorf("Value received: %v expected %v", ssh.FingerprintSHA256(missing.PublicKey), ssh.FingerprintSHA256(expected))
+					}
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			expected := readPublicKey(t, tt.pub)
+			if !bytes.Equal(signer.PublicKey().Marshal(), expected.Marshal()) {
+				t.Errorf("Value received: %v expected %v", ssh.FingerprintSHA256(signer.PublicKey()), ssh.FingerprintSHA256(expected))
+			}
+			sig, err := signer.Sign(nil, []byte("data"))
+			if err == nil {
+				err = expected.Verify([]byte("data"), sig)
+			}
+			if err != nil {
+				t.Errorf("Value received: %v expected %v", err, nil)
+			}
+		})
+	}
+}